@@ -0,0 +1,95 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+const (
+	AddressingIPv4       = "ipv4"
+	AddressingIPv6       = "ipv6"
+	AddressingPrivateDNS = "private-dns"
+)
+
+// memberAddressing decides which host identifies a cluster member in etcd
+// peer and client urls: one of its addresses, its private dns name or the
+// output of a template executed against the member.
+type memberAddressing struct {
+	strategy string
+	tmpl     *template.Template
+}
+
+func addressingFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name: "addressing",
+			Value: AddressingIPv4,
+			Usage: "host used in etcd urls (ipv4, ipv6, private-dns or a template like {{.Id}}.etcd.example.com)",
+		},
+	}
+}
+
+func newMemberAddressing(c *cli.Context) (memberAddressing, error) {
+	return parseMemberAddressing(c.String("addressing"))
+}
+
+// parseMemberAddressing returns the addressing of the given strategy, any
+// value other than the predefined ones must be a template.
+func parseMemberAddressing(strategy string) (memberAddressing, error) {
+	switch strategy {
+	case AddressingIPv4, AddressingIPv6, AddressingPrivateDNS:
+		return memberAddressing{strategy: strategy}, nil
+	}
+
+	if !strings.Contains(strategy, "{{") {
+		return memberAddressing{}, fmt.Errorf("unknown addressing: %s", strategy)
+	}
+
+	tmpl, err := template.New("addressing").Option("missingkey=error").Parse(strategy)
+	if err != nil {
+		return memberAddressing{}, err
+	}
+
+	return memberAddressing{strategy: strategy, tmpl: tmpl}, nil
+}
+
+// host returns the host of the given member, it fails if the member lacks
+// the address or name the strategy relies on.
+func (addressing memberAddressing) host(member providers.Member) (string, error) {
+	var host string
+	switch {
+	case addressing.tmpl != nil:
+		out, err := executeTemplate(addressing.tmpl, member)
+		if err != nil {
+			return "", err
+		}
+		host = strings.TrimSpace(out)
+	case addressing.strategy == AddressingIPv6:
+		host = member.IPv6Address
+	case addressing.strategy == AddressingPrivateDNS:
+		host = member.PrivateDNSName
+	default:
+		host = member.PrivateAddress
+	}
+
+	if host == "" {
+		return "", fmt.Errorf("member %s has no %s host", member.Id, addressing.strategy)
+	}
+
+	return host, nil
+}
+
+// sameHost compares hosts as found in etcd urls and as built by the
+// addressing strategy, ips are compared by value and names case insensitively.
+func sameHost(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA != nil || ipB != nil {
+		return ipA != nil && ipB != nil && ipA.Equal(ipB)
+	}
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
@@ -0,0 +1,87 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+func TestMemberAddressingHost(t *testing.T) {
+	member := providers.Member{
+		Id:             "i-1",
+		PrivateAddress: "10.0.0.1",
+		IPv6Address:    "fd00::1",
+		PrivateDNSName: "ip-10-0-0-1.ec2.internal",
+		Tags:           map[string]string{"role": "etcd"},
+	}
+
+	tests := []struct {
+		strategy string
+		member   providers.Member
+		host     string
+		fails    bool
+	}{
+		{strategy: AddressingIPv4, member: member, host: "10.0.0.1"},
+		{strategy: AddressingIPv6, member: member, host: "fd00::1"},
+		{strategy: AddressingPrivateDNS, member: member, host: "ip-10-0-0-1.ec2.internal"},
+		{strategy: "{{.Id}}.{{.Tags.role}}.example.com", member: member, host: "i-1.etcd.example.com"},
+		{strategy: " {{.Id}}.example.com\n", member: member, host: "i-1.example.com"},
+		{strategy: AddressingIPv6, member: providers.Member{Id: "i-2", PrivateAddress: "10.0.0.2"}, fails: true},
+		{strategy: AddressingPrivateDNS, member: providers.Member{Id: "i-2", PrivateAddress: "10.0.0.2"}, fails: true},
+		// missingkey=error
+		{strategy: "{{.Tags.zone}}.example.com", member: member, fails: true},
+		{strategy: "{{.Tags.role}}", member: providers.Member{Id: "i-2"}, fails: true},
+		{strategy: "{{.Unknown}}.example.com", member: member, fails: true},
+		{strategy: "{{if false}}x{{end}}", member: member, fails: true},
+	}
+
+	for _, test := range tests {
+		addressing, err := parseMemberAddressing(test.strategy)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.strategy, err)
+			continue
+		}
+
+		host, err := addressing.host(test.member)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", test.strategy, host)
+			}
+			continue
+		}
+		if err != nil || host != test.host {
+			t.Errorf("%q: got %q, %v, expected %q", test.strategy, host, err, test.host)
+		}
+	}
+}
+
+func TestParseMemberAddressing(t *testing.T) {
+	for _, strategy := range []string{"ipv5", "", "{{.Id", "{{.Id | nosuchfunc}}"} {
+		if _, err := parseMemberAddressing(strategy); err == nil {
+			t.Errorf("%q: expected an error", strategy)
+		}
+	}
+}
+
+func TestSameHost(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"10.0.0.1", "10.0.0.1", true},
+		{"10.0.0.1", "10.0.0.2", false},
+		{"fd00::1", "fd00:0:0::1", true},
+		{"fd00::1", "fd00::2", false},
+		{"n1.Example.com.", "N1.example.com", true},
+		{"n1.example.com", "n1.example.com.", true},
+		{"n1.example.com", "n2.example.com", false},
+		{"10.0.0.1", "n1.example.com", false},
+		{"::ffff:10.0.0.1", "10.0.0.1", true},
+	}
+
+	for _, test := range tests {
+		if same := sameHost(test.a, test.b); same != test.same {
+			t.Errorf("sameHost(%q, %q) = %t, expected %t", test.a, test.b, same, test.same)
+		}
+	}
+}
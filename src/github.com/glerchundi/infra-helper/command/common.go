@@ -0,0 +1,20 @@
+package command
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+// newProvider creates the provider selected with the global --provider flag.
+func newProvider(c *cli.Context) (providers.Provider, error) {
+	return providers.New(c.GlobalString("provider"), c)
+}
+
+// concatFlags joins the flag sets shared by several commands.
+func concatFlags(flagSets ...[]cli.Flag) []cli.Flag {
+	flags := make([]cli.Flag, 0)
+	for _, flagSet := range flagSets {
+		flags = append(flags, flagSet...)
+	}
+	return flags
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+// etcdEndpoints builds the peer and client urls of a host and holds the peer
+// TLS material etcd is told to serve the advertised peer urls with.
+type etcdEndpoints struct {
+	peerScheme   string
+	peerPort     int
+	clientScheme string
+	clientPort   int
+
+	peerCAFile   string
+	peerCertFile string
+	peerKeyFile  string
+}
+
+func endpointsFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name: "peer-scheme",
+			Value: "http",
+			Usage: "scheme of etcd peer urls (http, https)",
+		},
+		cli.IntFlag{
+			Name: "peer-port",
+			Value: util.DefaultEtcdPeerPort,
+			Usage: "port of etcd peer urls",
+		},
+		cli.StringFlag{
+			Name: "client-scheme",
+			Value: "http",
+			Usage: "scheme of etcd client urls (http, https)",
+		},
+		cli.IntFlag{
+			Name: "client-port",
+			Value: util.DefaultEtcdClientPort,
+			Usage: "port of etcd client urls",
+		},
+		cli.StringFlag{
+			Name: "client-ca-file",
+			Usage: "CA bundle used to verify etcd client urls",
+			EnvVar: "ETCDCTL_CA_FILE",
+		},
+		cli.StringFlag{
+			Name: "client-cert-file",
+			Usage: "certificate used to connect to etcd client urls",
+			EnvVar: "ETCDCTL_CERT_FILE",
+		},
+		cli.StringFlag{
+			Name: "client-key-file",
+			Usage: "key used to connect to etcd client urls",
+			EnvVar: "ETCDCTL_KEY_FILE",
+		},
+		cli.StringFlag{
+			Name: "peer-ca-file",
+			Usage: "CA bundle etcd verifies peers with (written as ETCD_PEER_TRUSTED_CA_FILE)",
+		},
+		cli.StringFlag{
+			Name: "peer-cert-file",
+			Usage: "certificate etcd serves peer urls with (written as ETCD_PEER_CERT_FILE)",
+		},
+		cli.StringFlag{
+			Name: "peer-key-file",
+			Usage: "key etcd serves peer urls with (written as ETCD_PEER_KEY_FILE)",
+		},
+	}
+}
+
+// newEtcdEndpoints reads the endpoint flags, the client TLS material is set
+// up for every etcd connection made from now on.
+func newEtcdEndpoints(c *cli.Context) (etcdEndpoints, error) {
+	endpoints := etcdEndpoints{
+		peerScheme:   c.String("peer-scheme"),
+		peerPort:     c.Int("peer-port"),
+		clientScheme: c.String("client-scheme"),
+		clientPort:   c.Int("client-port"),
+		peerCAFile:   c.String("peer-ca-file"),
+		peerCertFile: c.String("peer-cert-file"),
+		peerKeyFile:  c.String("peer-key-file"),
+	}
+
+	for _, scheme := range []string{endpoints.peerScheme, endpoints.clientScheme} {
+		if scheme != "http" && scheme != "https" {
+			return etcdEndpoints{}, fmt.Errorf("unknown scheme: %s", scheme)
+		}
+	}
+
+	util.SetEtcdClientTLS(c.String("client-ca-file"), c.String("client-cert-file"), c.String("client-key-file"))
+
+	return endpoints, nil
+}
+
+func (endpoints etcdEndpoints) peerURL(host string) string {
+	return util.EtcdURL(endpoints.peerScheme, host, endpoints.peerPort)
+}
+
+func (endpoints etcdEndpoints) clientURL(host string) string {
+	return util.EtcdURL(endpoints.clientScheme, host, endpoints.clientPort)
+}
+
+// writePeerTLSVariables writes the peer TLS environment variables which were
+// set.
+func (endpoints etcdEndpoints) writePeerTLSVariables(buffer *bytes.Buffer) {
+	if endpoints.peerCAFile != "" {
+		buffer.WriteString(fmt.Sprintf("ETCD_PEER_TRUSTED_CA_FILE=%s\n", endpoints.peerCAFile))
+	}
+	if endpoints.peerCertFile != "" {
+		buffer.WriteString(fmt.Sprintf("ETCD_PEER_CERT_FILE=%s\n", endpoints.peerCertFile))
+	}
+	if endpoints.peerKeyFile != "" {
+		buffer.WriteString(fmt.Sprintf("ETCD_PEER_KEY_FILE=%s\n", endpoints.peerKeyFile))
+	}
+}
@@ -0,0 +1,226 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coreos/etcd/client"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/providers/static"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+// fakeEtcd serves the members, keys and health api of a single healthy etcd
+// member, keys support the conditions used by the join lock.
+type fakeEtcd struct {
+	sync.Mutex
+	members []client.Member
+	keys    map[string]string
+	added   int
+	writes  []string
+}
+
+func newFakeEtcd(t *testing.T, etcdMembers ...client.Member) (*httptest.Server, *fakeEtcd) {
+	etcd := &fakeEtcd{members: etcdMembers, keys: make(map[string]string)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etcd.Lock()
+		defer etcd.Unlock()
+
+		w.Header().Set("X-Etcd-Index", "1")
+		if r.Method != "GET" {
+			etcd.writes = append(etcd.writes, r.Method+" "+r.URL.Path)
+		}
+		switch {
+		case r.URL.Path == "/v2/members" && r.Method == "GET":
+			json.NewEncoder(w).Encode(map[string][]client.Member{"members": etcd.members})
+		case r.URL.Path == "/v2/members" && r.Method == "POST":
+			var request struct {
+				PeerURLs []string `json:"peerURLs"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			etcd.added++
+			etcdMember := client.Member{ID: fmt.Sprintf("added%d", etcd.added), PeerURLs: request.PeerURLs}
+			etcd.members = append(etcd.members, etcdMember)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(etcdMember)
+		case strings.HasPrefix(r.URL.Path, "/v2/members/") && r.Method == "DELETE":
+			id := strings.TrimPrefix(r.URL.Path, "/v2/members/")
+			for i, etcdMember := range etcd.members {
+				if etcdMember.ID == id {
+					etcd.members = append(etcd.members[:i], etcd.members[i+1:]...)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusGone)
+		case r.URL.Path == "/health":
+			fmt.Fprint(w, `{"health": "true"}`)
+		case strings.HasPrefix(r.URL.Path, "/v2/keys/"):
+			etcd.serveKey(w, r, strings.TrimPrefix(r.URL.Path, "/v2/keys"))
+		default:
+			t.Logf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, etcd
+}
+
+func (etcd *fakeEtcd) serveKey(w http.ResponseWriter, r *http.Request, key string) {
+	r.ParseForm()
+	value, exists := etcd.keys[key]
+
+	fail := func(status, code int) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(client.Error{Code: code, Message: http.StatusText(status)})
+	}
+
+	switch {
+	case r.Method != "GET" && r.URL.Query().Get("prevExist") == "false" && exists:
+		fail(http.StatusPreconditionFailed, client.ErrorCodeNodeExist)
+		return
+	case (r.Method == "GET" || r.URL.Query().Get("prevValue") != "") && !exists:
+		fail(http.StatusNotFound, client.ErrorCodeKeyNotFound)
+		return
+	case r.URL.Query().Get("prevValue") != "" && r.URL.Query().Get("prevValue") != value:
+		fail(http.StatusPreconditionFailed, client.ErrorCodeTestFailed)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		value = r.PostForm.Get("value")
+		etcd.keys[key] = value
+	case "DELETE":
+		delete(etcd.keys, key)
+	}
+
+	json.NewEncoder(w).Encode(client.Response{Action: strings.ToLower(r.Method), Node: &client.Node{Key: key, Value: value}})
+}
+
+func (etcd *fakeEtcd) memberIDs() []string {
+	etcd.Lock()
+	defer etcd.Unlock()
+	return etcdMemberIDs(etcd.members)
+}
+
+// writeRequests returns the method and path of every request but reads.
+func (etcd *fakeEtcd) writeRequests() []string {
+	etcd.Lock()
+	defer etcd.Unlock()
+	return append([]string{}, etcd.writes...)
+}
+
+func (etcd *fakeEtcd) key(key string) string {
+	etcd.Lock()
+	defer etcd.Unlock()
+	return etcd.keys[key]
+}
+
+func etcdMemberIDs(etcdMembers []client.Member) []string {
+	ids := make([]string, 0)
+	for _, etcdMember := range etcdMembers {
+		ids = append(ids, etcdMember.ID)
+	}
+	return ids
+}
+
+// etcdMember returns a member peering at the given host, unstarted if it has
+// no name.
+func etcdMember(id, name, host string) client.Member {
+	return client.Member{ID: id, Name: name, PeerURLs: []string{"http://" + net.JoinHostPort(host, "2380")}}
+}
+
+// fakeProvider lists the given members, which unlike the static ones may
+// have lifecycle states and health statuses.
+type fakeProvider struct {
+	self    string
+	members map[string]providers.Member
+}
+
+func (provider *fakeProvider) GetInstanceId() (string, error) {
+	return provider.self, nil
+}
+
+func (provider *fakeProvider) GetInstancePrivateAddress() (string, error) {
+	return provider.members[provider.self].PrivateAddress, nil
+}
+
+func (provider *fakeProvider) GetClusterMembers() (map[string]providers.Member, error) {
+	return provider.members, nil
+}
+
+func (provider *fakeProvider) GetClusterMembersByName(name string) (map[string]providers.Member, error) {
+	return nil, fmt.Errorf("cluster %s not found", name)
+}
+
+// newStaticProvider writes the given instance ids and addresses as the
+// default cluster of a static file, self is the instance id of this one.
+func newStaticProvider(t *testing.T, self string, addresses map[string]string) providers.Provider {
+	data, err := json.Marshal(map[string]map[string]string{static.DefaultCluster: addresses})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "cluster.json")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := static.New(file, static.DefaultCluster, self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// testSyncOptions returns the options reaching etcd client urls at the port
+// of the given server.
+func testSyncOptions(t *testing.T, server *httptest.Server) syncOptions {
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPort, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return syncOptions{
+		maxRemovals:    1,
+		dataDir:        t.TempDir(),
+		rejoinStrategy: RejoinReplace,
+		addressing:     memberAddressing{strategy: AddressingIPv4},
+		endpoints: etcdEndpoints{
+			peerScheme:   "http",
+			peerPort:     2380,
+			clientScheme: "http",
+			clientPort:   clientPort,
+		},
+	}
+}
+
+func sortedList(value string) []string {
+	list := util.SplitList(value)
+	sort.Strings(list)
+	return list
+}
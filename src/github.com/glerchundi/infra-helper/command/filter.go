@@ -0,0 +1,117 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+// memberFilter restricts cluster members to the given lifecycle states and
+// health statuses, an empty list matches any value. Providers knowing nothing
+// about states or statuses leave them empty, so the restriction is dropped if
+// no member has any (see applicable).
+type memberFilter struct {
+	lifecycleStates []string
+	healthStatuses  []string
+}
+
+func memberFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name: "lifecycle-states",
+			Value: "InService",
+			Usage: "comma separated lifecycle states of the members to include (empty means any)",
+		},
+		cli.StringFlag{
+			Name: "health-statuses",
+			Value: "Healthy",
+			Usage: "comma separated health statuses of the members to include (empty means any)",
+		},
+	}
+}
+
+func newMemberFilter(c *cli.Context) memberFilter {
+	return memberFilter{
+		lifecycleStates: util.SplitList(c.String("lifecycle-states")),
+		healthStatuses:  util.SplitList(c.String("health-statuses")),
+	}
+}
+
+// applicable returns the filter without the restrictions on the states or
+// statuses none of the given members has.
+func (filter memberFilter) applicable(members map[string]providers.Member) memberFilter {
+	hasLifecycleStates, hasHealthStatuses := false, false
+	for _, member := range members {
+		hasLifecycleStates = hasLifecycleStates || member.LifecycleState != ""
+		hasHealthStatuses = hasHealthStatuses || member.HealthStatus != ""
+	}
+
+	if !hasLifecycleStates {
+		filter.lifecycleStates = nil
+	}
+	if !hasHealthStatuses {
+		filter.healthStatuses = nil
+	}
+
+	return filter
+}
+
+func (filter memberFilter) matches(member providers.Member) bool {
+	return matchesAny(filter.lifecycleStates, member.LifecycleState) &&
+		matchesAny(filter.healthStatuses, member.HealthStatus)
+}
+
+// clusterMembers holds the members matching the filter, the ones which are
+// being terminated and every member listed, all of them indexed by name.
+type clusterMembers struct {
+	byName      map[string]providers.Member
+	terminating map[string]providers.Member
+	all         map[string]providers.Member
+}
+
+// getClusterMembers retrieves the cluster members (of the named cluster if
+// name is not empty) matching the filter. The member named self is always
+// included, it is probably still being launched.
+func getClusterMembers(provider providers.Provider, name string, filter memberFilter, self string) (*clusterMembers, error) {
+	var all map[string]providers.Member
+	var err error
+	if name == "" {
+		all, err = provider.GetClusterMembers()
+	} else {
+		all, err = provider.GetClusterMembersByName(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	members := &clusterMembers{
+		byName:      make(map[string]providers.Member),
+		terminating: make(map[string]providers.Member),
+		all:         all,
+	}
+	filter = filter.applicable(all)
+	for memberName, member := range all {
+		if strings.HasPrefix(member.LifecycleState, "Terminating") {
+			members.terminating[memberName] = member
+		}
+		if memberName == self || filter.matches(member) {
+			members.byName[memberName] = member
+		}
+	}
+
+	return members, nil
+}
+
+func matchesAny(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
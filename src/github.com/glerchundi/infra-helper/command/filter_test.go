@@ -0,0 +1,84 @@
+package command
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+func TestGetClusterMembersFilter(t *testing.T) {
+	filter := memberFilter{lifecycleStates: []string{"InService"}, healthStatuses: []string{"Healthy"}}
+
+	tests := []struct {
+		name        string
+		members     map[string]providers.Member
+		byName      []string
+		terminating []string
+	}{
+		{
+			name: "states and statuses",
+			members: map[string]providers.Member{
+				"a": {LifecycleState: "InService", HealthStatus: "Healthy"},
+				"b": {LifecycleState: "Pending", HealthStatus: "Healthy"},
+				"c": {LifecycleState: "InService", HealthStatus: "Unhealthy"},
+				"d": {LifecycleState: "Terminating:Wait", HealthStatus: "Healthy"},
+				"e": {HealthStatus: "Healthy"},
+				"f": {LifecycleState: "Pending"},
+			},
+			byName:      []string{"a", "f"},
+			terminating: []string{"d"},
+		},
+		{
+			name: "states only",
+			members: map[string]providers.Member{
+				"a": {LifecycleState: "InService"},
+				"b": {LifecycleState: "Pending"},
+				"c": {},
+			},
+			byName: []string{"a"},
+		},
+		{
+			name: "neither states nor statuses",
+			members: map[string]providers.Member{
+				"a": {},
+				"b": {},
+			},
+			byName: []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		clusterMembers, err := getClusterMembers(&fakeProvider{self: "f", members: test.members}, "", filter, "f")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if actual := memberNames(clusterMembers.byName); !reflect.DeepEqual(actual, test.byName) {
+			t.Errorf("%s: included %v, expected %v", test.name, actual, test.byName)
+		}
+
+		expected := test.terminating
+		if expected == nil {
+			expected = []string{}
+		}
+		if actual := memberNames(clusterMembers.terminating); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: terminating %v, expected %v", test.name, actual, expected)
+		}
+
+		if len(clusterMembers.all) != len(test.members) {
+			t.Errorf("%s: expected every member, got %v", test.name, clusterMembers.all)
+		}
+	}
+}
+
+func memberNames(members map[string]providers.Member) []string {
+	names := make([]string, 0)
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,162 @@
+package command
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+func NewHandleLifecycleHooksCommand() cli.Command {
+	return cli.Command{
+		Name:  "handle-lifecycle-hooks",
+		Usage: `removes terminating instances from the "etcd" cluster as lifecycle hooks notify them`,
+		Flags: append([]cli.Flag {
+			cli.DurationFlag{
+				Name: "wait-time",
+				Value: 20 * time.Second,
+				Usage: "maximum time to wait for notifications per poll",
+			},
+			cli.DurationFlag{
+				Name: "retry-interval",
+				Value: 10 * time.Second,
+				Usage: "time to wait before polling again after a failure",
+			},
+			cli.BoolFlag{
+				Name: "dry-run",
+				Usage: "print the members which would be removed instead of removing them, terminations are not completed",
+			},
+		}, concatFlags(lockFlags(), memberFilterFlags(), addressingFlags(), endpointsFlags())...),
+		Action: handleHandleLifecycleHooks,
+	}
+}
+
+func handleHandleLifecycleHooks(c *cli.Context) {
+	addressing, err := newMemberAddressing(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	endpoints, err := newEtcdEndpoints(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// a termination removes its own member only
+	options := syncOptions{
+		maxRemovals: 1,
+		dryRun:      c.Bool("dry-run"),
+		lockKey:     c.String("lock-key"),
+		lockTTL:     c.Duration("lock-ttl"),
+		lockTimeout: c.Duration("lock-timeout"),
+		filter:      newMemberFilter(c),
+		addressing:  addressing,
+		endpoints:   endpoints,
+	}
+
+	provider, err := newProvider(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lifecycleProvider, ok := provider.(providers.LifecycleProvider)
+	if !ok {
+		log.Fatal(errors.New("provider doesn't support lifecycle hooks"))
+	}
+
+	// the holder isn't an instance id, this daemon may run off the cluster as
+	// long as the provider is able to tell the cluster from its configuration
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handleLifecycleHooks(provider, lifecycleProvider, lockHolder(hostname), c.Duration("wait-time"), c.Duration("retry-interval"), options)
+}
+
+// handleLifecycleHooks handles terminations as they are notified until it is
+// interrupted, polls which fail are retried after the given interval.
+func handleLifecycleHooks(provider providers.Provider, lifecycleProvider providers.LifecycleProvider, holder string, wait, retryInterval time.Duration, options syncOptions) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for {
+		var delay time.Duration
+		if err := handleTerminations(provider, lifecycleProvider, holder, wait, options); err != nil {
+			log.Printf("unable to receive terminations: %v\n", err)
+			delay = retryInterval
+		}
+
+		// the terminations being handled are never interrupted
+		select {
+		case sig := <-signals:
+			log.Printf("received %s, stopping.\n", sig)
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// handleTerminations removes the etcd member of every terminating instance
+// and then lets its termination go on. Terminations which fail are not
+// completed, they are notified again once their message becomes visible.
+func handleTerminations(provider providers.Provider, lifecycleProvider providers.LifecycleProvider, holder string, wait time.Duration, options syncOptions) error {
+	terminations, err := lifecycleProvider.ReceiveTerminations(wait)
+	if err != nil {
+		return err
+	}
+
+	for _, termination := range terminations {
+		log.Printf("instance %s is terminating\n", termination.InstanceId)
+
+		if err := removeTerminatingEtcdMember(provider, termination.InstanceId, holder, options); err != nil {
+			log.Printf("unable to remove instance %s: %v\n", termination.InstanceId, err)
+			continue
+		}
+
+		if options.dryRun {
+			continue
+		}
+
+		if err := lifecycleProvider.CompleteTermination(termination); err != nil {
+			log.Printf("unable to complete termination of %s: %v\n", termination.InstanceId, err)
+		}
+	}
+
+	return nil
+}
+
+// removeTerminatingEtcdMember removes the etcd member of the given instance
+// through any other cluster member.
+func removeTerminatingEtcdMember(provider providers.Provider, instanceId, holder string, options syncOptions) error {
+	clusterMembers, err := getClusterMembers(provider, "", options.filter, "")
+	if err != nil {
+		return err
+	}
+
+	// the instance is usually filtered out because of its lifecycle state, its
+	// host is only needed if the member never started
+	var host string
+	member, ok := clusterMembers.terminating[instanceId]
+	if !ok {
+		member, ok = clusterMembers.byName[instanceId]
+	}
+	if ok {
+		if host, err = options.addressing.host(member); err != nil {
+			log.Printf("unable to get host of instance %s: %v\n", instanceId, err)
+		}
+	}
+
+	etcdMembers, goodEtcdClientURL := listEtcdMembers(clusterMembers.byName, instanceId, options)
+	if etcdMembers == nil {
+		return errors.New("unable to reach any etcd member")
+	}
+
+	return removeInstanceEtcdMember(goodEtcdClientURL, instanceId, host, holder, options)
+}
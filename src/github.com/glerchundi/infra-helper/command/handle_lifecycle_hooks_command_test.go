@@ -0,0 +1,118 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+// fakeLifecycleProvider notifies the terminations of the given instances once
+// and records the completed ones.
+type fakeLifecycleProvider struct {
+	terminating []string
+	completed   []string
+}
+
+func (provider *fakeLifecycleProvider) ReceiveTerminations(wait time.Duration) ([]providers.Termination, error) {
+	if provider.terminating == nil {
+		return nil, errors.New("no queue")
+	}
+
+	terminations := make([]providers.Termination, 0)
+	for _, instanceId := range provider.terminating {
+		terminations = append(terminations, providers.Termination{InstanceId: instanceId})
+	}
+	provider.terminating = []string{}
+	return terminations, nil
+}
+
+func (provider *fakeLifecycleProvider) CompleteTermination(termination providers.Termination) error {
+	provider.completed = append(provider.completed, termination.InstanceId)
+	return nil
+}
+
+func TestHandleTerminations(t *testing.T) {
+	provider := &fakeProvider{members: map[string]providers.Member{
+		"n1": {Id: "n1", PrivateAddress: "127.0.0.1", LifecycleState: "InService"},
+		"n2": {Id: "n2", PrivateAddress: "127.0.0.2", LifecycleState: "InService"},
+		"n3": {Id: "n3", PrivateAddress: "127.0.0.3", LifecycleState: "Terminating:Wait"},
+		"n4": {Id: "n4", PrivateAddress: "127.0.0.4", LifecycleState: "Terminating:Wait"},
+	}}
+
+	tests := []struct {
+		name      string
+		self      []client.Member
+		healthy   []string
+		dryRun    bool
+		members   []string
+		completed []string
+	}{
+		{
+			name:      "started member",
+			self:      []client.Member{etcdMember("m3", "n3", "127.0.0.3")},
+			healthy:   []string{"m1", "m2", "m3"},
+			members:   []string{"m1", "m2"},
+			completed: []string{"n3"},
+		},
+		{
+			name:      "unstarted member",
+			self:      []client.Member{etcdMember("m3", "", "127.0.0.3")},
+			healthy:   []string{"m1", "m2"},
+			members:   []string{"m1", "m2"},
+			completed: []string{"n3"},
+		},
+		{
+			name:      "not a member",
+			healthy:   []string{"m1", "m2"},
+			members:   []string{"m1", "m2"},
+			completed: []string{"n3"},
+		},
+		{
+			name:    "removal breaking quorum",
+			self:    []client.Member{etcdMember("m3", "n3", "127.0.0.3")},
+			healthy: []string{"m1", "m3"},
+			members: []string{"m1", "m2", "m3"},
+		},
+		{
+			name:    "dry run",
+			self:    []client.Member{etcdMember("m3", "n3", "127.0.0.3")},
+			healthy: []string{"m1", "m2", "m3"},
+			dryRun:  true,
+			members: []string{"m1", "m2", "m3"},
+		},
+	}
+
+	for _, test := range tests {
+		etcdMembers := append([]client.Member{etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2")}, test.self...)
+		server, etcd := newFakeEtcd(t, etcdMembers...)
+		restore := stubEtcdMemberHealth(test.healthy...)
+
+		options := testSyncOptions(t, server)
+		options.filter = memberFilter{lifecycleStates: []string{"InService"}}
+		options.dryRun = test.dryRun
+
+		lifecycleProvider := &fakeLifecycleProvider{terminating: []string{"n3"}}
+		err := handleTerminations(provider, lifecycleProvider, lockHolder("daemon"), time.Second, options)
+		restore()
+		server.Close()
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, test.members) {
+			t.Errorf("%s: members %v, expected %v", test.name, actual, test.members)
+		}
+		if !reflect.DeepEqual(lifecycleProvider.completed, test.completed) {
+			t.Errorf("%s: completed %v, expected %v", test.name, lifecycleProvider.completed, test.completed)
+		}
+	}
+
+	// failing polls are reported
+	if err := handleTerminations(provider, &fakeLifecycleProvider{}, lockHolder("daemon"), time.Second, syncOptions{}); err == nil {
+		t.Errorf("expected an error receiving terminations")
+	}
+}
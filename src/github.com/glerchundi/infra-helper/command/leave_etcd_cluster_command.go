@@ -0,0 +1,156 @@
+package command
+
+import (
+	"errors"
+	"log"
+
+	"github.com/codegangsta/cli"
+	"github.com/coreos/etcd/client"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+func NewLeaveEtcdClusterCommand() cli.Command {
+	return cli.Command{
+		Name:  "leave-etcd-cluster",
+		Usage: `removes the "etcd" member of this instance from the cluster, i.e. before terminating it`,
+		Flags: append([]cli.Flag {
+			cli.BoolFlag{
+				Name: "dry-run",
+				Usage: "print the member which would be removed instead of removing it",
+			},
+		}, concatFlags(lockFlags(), memberFilterFlags(), addressingFlags(), endpointsFlags())...),
+		Action: handleLeaveEtcdCluster,
+	}
+}
+
+func handleLeaveEtcdCluster(c *cli.Context) {
+	addressing, err := newMemberAddressing(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	endpoints, err := newEtcdEndpoints(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	options := syncOptions{
+		maxRemovals: 1,
+		dryRun:      c.Bool("dry-run"),
+		lockKey:     c.String("lock-key"),
+		lockTTL:     c.Duration("lock-ttl"),
+		lockTimeout: c.Duration("lock-timeout"),
+		filter:      newMemberFilter(c),
+		addressing:  addressing,
+		endpoints:   endpoints,
+	}
+
+	provider, err := newProvider(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := leaveEtcdCluster(provider, options); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// leaveEtcdCluster removes the member of this instance through a healthy
+// peer, as long as the remaining members keep quorum.
+func leaveEtcdCluster(provider providers.Provider, options syncOptions) error {
+	instanceId, err := provider.GetInstanceId()
+	if err != nil {
+		return err
+	}
+
+	clusterMembers, err := getClusterMembers(provider, "", options.filter, instanceId)
+	if err != nil {
+		return err
+	}
+	clusterMembersByName := clusterMembers.byName
+
+	selfHost, err := getSelfHost(provider, instanceId, clusterMembers.all, options.addressing)
+	if err != nil {
+		return err
+	}
+
+	// this instance is going away, ask any other
+	etcdMembers, goodEtcdClientURL := listEtcdMembers(clusterMembersByName, instanceId, options)
+	if etcdMembers == nil {
+		return errors.New("unable to reach any etcd member")
+	}
+
+	return removeInstanceEtcdMember(goodEtcdClientURL, instanceId, selfHost, lockHolder(instanceId), options)
+}
+
+// removeInstanceEtcdMember removes the etcd member of the given instance,
+// found by name or by host if it never started, through a healthy peer. The
+// instance is known to be going away so the member is removed even if it is
+// healthy, as long as the remaining members keep quorum.
+func removeInstanceEtcdMember(etcdClientURL, instanceId, host, holder string, options syncOptions) error {
+	return withJoinLock(etcdClientURL, holder, options, func() error {
+		// members may have changed while waiting for the lock
+		etcdMembers, err := util.EtcdListMembers(etcdClientURL)
+		if err != nil {
+			return err
+		}
+
+		etcdMember := findEtcdMemberByName(etcdMembers, instanceId)
+		if etcdMember == nil && host != "" {
+			// unstarted members have no name yet
+			etcdMember, _ = findEtcdMemberByHost(etcdMembers, host)
+		}
+		if etcdMember == nil {
+			log.Printf("instance %s is not an etcd member, nothing to do\n", instanceId)
+			return nil
+		}
+
+		removals, err := safeRemovals(etcdMembers, []client.Member{*etcdMember}, map[string]bool{etcdMember.ID: true}, options.maxRemovals)
+		if err != nil {
+			return err
+		}
+
+		healthyEtcdClientURL, err := findHealthyEtcdClientURL(etcdMembers, etcdMember.ID, etcdClientURL)
+		if err != nil {
+			return err
+		}
+
+		if options.dryRun {
+			log.Printf("would remove etcd member: %s%s\n", etcdMember.ID, unstartedSuffix(*etcdMember))
+			return nil
+		}
+
+		return removeEtcdMembers(healthyEtcdClientURL, removals)
+	})
+}
+
+func findEtcdMemberByName(etcdMembers []client.Member, name string) *client.Member {
+	for i, etcdMember := range etcdMembers {
+		if etcdMember.Name == name {
+			return &etcdMembers[i]
+		}
+	}
+	return nil
+}
+
+// findHealthyEtcdClientURL returns the given client url if healthy or else
+// any healthy client url of the members other than the skipped one.
+func findHealthyEtcdClientURL(etcdMembers []client.Member, skipID, etcdClientURL string) (string, error) {
+	if util.EtcdIsHealthy(etcdClientURL) {
+		return etcdClientURL, nil
+	}
+
+	for _, etcdMember := range etcdMembers {
+		if etcdMember.ID == skipID {
+			continue
+		}
+		for _, clientURL := range etcdMember.ClientURLs {
+			if util.EtcdIsHealthy(clientURL) {
+				return clientURL, nil
+			}
+		}
+	}
+
+	return "", errors.New("no healthy etcd member found")
+}
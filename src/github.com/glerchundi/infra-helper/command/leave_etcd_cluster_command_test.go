@@ -0,0 +1,98 @@
+package command
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/client"
+)
+
+func TestLeaveEtcdCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		self    []client.Member
+		healthy []string
+		dryRun  bool
+		members []string
+		fails   bool
+	}{
+		{
+			name:    "by name",
+			self:    []client.Member{etcdMember("m3", "n3", "127.0.0.3")},
+			healthy: []string{"m1", "m2", "m3"},
+			members: []string{"m1", "m2"},
+		},
+		{
+			name:    "by host if it never started",
+			self:    []client.Member{etcdMember("m3", "", "127.0.0.3")},
+			healthy: []string{"m1", "m2"},
+			members: []string{"m1", "m2"},
+		},
+		{
+			name:    "not a member",
+			healthy: []string{"m1", "m2"},
+			members: []string{"m1", "m2"},
+		},
+		{
+			name:    "breaking quorum",
+			self:    []client.Member{etcdMember("m3", "n3", "127.0.0.3")},
+			healthy: []string{"m1", "m3"},
+			members: []string{"m1", "m2", "m3"},
+			fails:   true,
+		},
+		{
+			name:    "dry run",
+			self:    []client.Member{etcdMember("m3", "n3", "127.0.0.3")},
+			healthy: []string{"m1", "m2", "m3"},
+			dryRun:  true,
+			members: []string{"m1", "m2", "m3"},
+		},
+	}
+
+	for _, test := range tests {
+		etcdMembers := append([]client.Member{etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2")}, test.self...)
+		server, etcd := newFakeEtcd(t, etcdMembers...)
+		restore := stubEtcdMemberHealth(test.healthy...)
+
+		options := testSyncOptions(t, server)
+		options.dryRun = test.dryRun
+
+		err := leaveEtcdCluster(newStaticProvider(t, "n3", testAddresses), options)
+		restore()
+		server.Close()
+
+		if test.fails && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !test.fails && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+
+		if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, test.members) {
+			t.Errorf("%s: members %v, expected %v", test.name, actual, test.members)
+		}
+	}
+}
+
+func TestFindHealthyEtcdClientURL(t *testing.T) {
+	server, _ := newFakeEtcd(t)
+	defer server.Close()
+
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	etcdMembers := []client.Member{
+		{ID: "m1", ClientURLs: []string{down.URL}},
+		{ID: "m2", ClientURLs: []string{down.URL, server.URL}},
+	}
+
+	if url, err := findHealthyEtcdClientURL(etcdMembers, "m3", server.URL); err != nil || url != server.URL {
+		t.Errorf("healthy url: got %q, %v", url, err)
+	}
+	if url, err := findHealthyEtcdClientURL(etcdMembers, "m3", down.URL); err != nil || url != server.URL {
+		t.Errorf("unhealthy url: got %q, %v", url, err)
+	}
+	if url, err := findHealthyEtcdClientURL(etcdMembers, "m2", down.URL); err == nil {
+		t.Errorf("expected an error without other healthy members, got %q", url)
+	}
+}
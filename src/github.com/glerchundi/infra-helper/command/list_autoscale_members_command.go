@@ -14,21 +14,24 @@ import (
 
 	"github.com/codegangsta/cli"
 	"github.com/glerchundi/infra-helper/providers"
-	"github.com/glerchundi/infra-helper/providers/aws"
 )
 
-type NameAndAddress struct {
-	Name string
-    Address string
+// MemberData is what the format template is executed against, every member
+// field is available (i.e. {{.Zone}} or {{index .Tags "Name"}}) besides the
+// original Name and Address.
+type MemberData struct {
+	providers.Member
+	Name    string
+	Address string
 }
 
 func NewListAutoscaleMembersCommand() cli.Command {
 	return cli.Command{
 		Name:  "list-autoscale-members",
-		Flags: []cli.Flag {
+		Flags: append([]cli.Flag {
 			cli.StringFlag{
 				Name: "name, n",
-				Usage: "search by name",
+				Usage: "search by name (comma separated names are merged into one cluster)",
 			},
 			cli.StringFlag{
 				Name: "format, f",
@@ -44,7 +47,7 @@ func NewListAutoscaleMembersCommand() cli.Command {
 				Name: "out, o",
 				Usage: "save output to a file",
 			},
-		},
+		}, memberFilterFlags()...),
 		Action: handleListAutoscaleMembers,
 	}
 }
@@ -68,22 +71,17 @@ func handleListAutoscaleMembers(c *cli.Context) {
 		log.Fatal(err)
 	}
 
-	// for now, just AWS provider
-	var provider providers.Provider = aws.New()
+	provider, err := newProvider(c)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-    // retrieve cluster members
-	var clusterMembersByName map[string]string = nil
-	if name == "" {
-		clusterMembersByName, err = provider.GetClusterMembers()
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		clusterMembersByName, err = provider.GetClusterMembersByName(name)
-		if err != nil {
-			log.Fatal(err)
-		}
+	// retrieve cluster members
+	clusterMembers, err := getClusterMembers(provider, name, newMemberFilter(c), "")
+	if err != nil {
+		log.Fatal(err)
 	}
+	clusterMembersByName := clusterMembers.byName
 
 	// create intermediate key map
 	sortedNames := make([]string, 0)
@@ -94,15 +92,15 @@ func handleListAutoscaleMembers(c *cli.Context) {
 	// do string sorting
 	sort.Strings(sortedNames)
 
-	// create final sorted array of Name & Addresses
-	sortedNameAndAddresses := make([]NameAndAddress, 0)
+	// create final sorted array of members
+	sortedMembers := make([]MemberData, 0)
 	for _, name := range sortedNames {
-		nameAndAddress := NameAndAddress{name, clusterMembersByName[name]}
-		sortedNameAndAddresses = append(sortedNameAndAddresses, nameAndAddress)
+		member := clusterMembersByName[name]
+		sortedMembers = append(sortedMembers, MemberData{member, name, member.PrivateAddress})
 	}
 
 	// loop over sorted name (which are the keys in the map)
-	data, err := executeTemplate(tmpl, sortedNameAndAddresses)
+	data, err := executeTemplate(tmpl, sortedMembers)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,83 @@
+package command
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/coreos/etcd/client"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+// quorum returns the minimum number of members required to reach consensus
+// in a cluster of the given size.
+func quorum(size int) int {
+	return size/2 + 1
+}
+
+// isEtcdMemberHealthy is replaced in tests to avoid network calls.
+var isEtcdMemberHealthy = checkEtcdMemberHealth
+
+// checkEtcdMemberHealth checks whether any of the member client urls reports
+// itself as healthy. Unstarted members have no client urls so they are never
+// healthy.
+func checkEtcdMemberHealth(etcdMember client.Member) bool {
+	for _, clientURL := range etcdMember.ClientURLs {
+		if util.EtcdIsHealthy(clientURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeRemovals filters out removal candidates which are still healthy (the
+// 'autoscale' information is probably partial) unless they are known to be
+// terminating, and refuses to remove anything if doing so would exceed
+// maxRemovals or leave the cluster without quorum.
+func safeRemovals(etcdMembers, candidates []client.Member, terminating map[string]bool, maxRemovals int) ([]client.Member, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	removals := make([]client.Member, 0)
+	for _, candidate := range candidates {
+		if !terminating[candidate.ID] && isEtcdMemberHealthy(candidate) {
+			log.Printf("etcd member %s is healthy but not listed as a cluster member, skipping removal\n", candidate.ID)
+			continue
+		}
+		removals = append(removals, candidate)
+	}
+
+	if len(removals) == 0 {
+		return nil, nil
+	}
+
+	if maxRemovals >= 0 && len(removals) > maxRemovals {
+		return nil, fmt.Errorf("refusing to remove %d etcd members, at most %d allowed per run", len(removals), maxRemovals)
+	}
+
+	// removing more members than the cluster is able to tolerate means that the
+	// member list is not trustworthy
+	if len(etcdMembers)-len(removals) < quorum(len(etcdMembers)) {
+		return nil, fmt.Errorf("refusing to remove %d of %d etcd members, it would break quorum", len(removals), len(etcdMembers))
+	}
+
+	isRemoval := make(map[string]bool)
+	for _, removal := range removals {
+		isRemoval[removal.ID] = true
+	}
+
+	// remaining healthy members must be able to reach consensus once the
+	// removals are done
+	healthy := 0
+	for _, etcdMember := range etcdMembers {
+		if !isRemoval[etcdMember.ID] && isEtcdMemberHealthy(etcdMember) {
+			healthy++
+		}
+	}
+
+	if size := len(etcdMembers) - len(removals); healthy < quorum(size) {
+		return nil, fmt.Errorf("refusing to remove etcd members, only %d healthy members would remain out of %d", healthy, size)
+	}
+
+	return removals, nil
+}
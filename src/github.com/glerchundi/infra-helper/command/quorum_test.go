@@ -0,0 +1,178 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/client"
+)
+
+// stubEtcdMemberHealth makes the members with the given ids healthy, and any
+// other unhealthy, until the returned function is called.
+func stubEtcdMemberHealth(healthyIDs ...string) func() {
+	healthy := make(map[string]bool)
+	for _, id := range healthyIDs {
+		healthy[id] = true
+	}
+
+	isEtcdMemberHealthy = func(etcdMember client.Member) bool {
+		return healthy[etcdMember.ID]
+	}
+
+	return func() { isEtcdMemberHealthy = checkEtcdMemberHealth }
+}
+
+func etcdMembersWithIDs(ids ...string) []client.Member {
+	etcdMembers := make([]client.Member, 0)
+	for _, id := range ids {
+		etcdMembers = append(etcdMembers, client.Member{ID: id, Name: "n" + id})
+	}
+	return etcdMembers
+}
+
+func TestQuorum(t *testing.T) {
+	for size, expected := range map[int]int{1: 1, 2: 2, 3: 2, 4: 3, 5: 3, 6: 4, 7: 4} {
+		if actual := quorum(size); actual != expected {
+			t.Errorf("quorum(%d) = %d, expected %d", size, actual, expected)
+		}
+	}
+}
+
+func TestSafeRemovals(t *testing.T) {
+	tests := []struct {
+		name        string
+		members     []string
+		healthy     []string
+		candidates  []string
+		terminating []string
+		maxRemovals int
+		removals    []string
+		fails       bool
+	}{
+		{
+			name:        "no candidates",
+			members:     []string{"a", "b", "c"},
+			healthy:     []string{"a", "b", "c"},
+			maxRemovals: 1,
+		},
+		{
+			name:        "unhealthy candidate",
+			members:     []string{"a", "b", "c"},
+			healthy:     []string{"a", "b"},
+			candidates:  []string{"c"},
+			maxRemovals: 1,
+			removals:    []string{"c"},
+		},
+		{
+			name:        "healthy candidate is skipped",
+			members:     []string{"a", "b", "c"},
+			healthy:     []string{"a", "b", "c"},
+			candidates:  []string{"c"},
+			maxRemovals: 1,
+		},
+		{
+			name:        "healthy terminating candidate",
+			members:     []string{"a", "b", "c"},
+			healthy:     []string{"a", "b", "c"},
+			candidates:  []string{"c"},
+			terminating: []string{"c"},
+			maxRemovals: 1,
+			removals:    []string{"c"},
+		},
+		{
+			name:        "zero max removals",
+			members:     []string{"a", "b", "c"},
+			healthy:     []string{"a", "b"},
+			candidates:  []string{"c"},
+			maxRemovals: 0,
+			fails:       true,
+		},
+		{
+			name:        "more removals than allowed",
+			members:     []string{"a", "b", "c", "d", "e"},
+			healthy:     []string{"a", "b", "c"},
+			candidates:  []string{"d", "e"},
+			maxRemovals: 1,
+			fails:       true,
+		},
+		{
+			name:        "unlimited removals",
+			members:     []string{"a", "b", "c", "d", "e"},
+			healthy:     []string{"a", "b", "c"},
+			candidates:  []string{"d", "e"},
+			maxRemovals: -1,
+			removals:    []string{"d", "e"},
+		},
+		{
+			name:        "odd size losing quorum",
+			members:     []string{"a", "b", "c"},
+			healthy:     []string{"a"},
+			candidates:  []string{"b", "c"},
+			maxRemovals: -1,
+			fails:       true,
+		},
+		{
+			name:        "even size keeping quorum",
+			members:     []string{"a", "b", "c", "d"},
+			healthy:     []string{"a", "b", "c"},
+			candidates:  []string{"d"},
+			maxRemovals: -1,
+			removals:    []string{"d"},
+		},
+		{
+			name:        "even size losing quorum",
+			members:     []string{"a", "b", "c", "d"},
+			healthy:     []string{"a", "b"},
+			candidates:  []string{"c", "d"},
+			maxRemovals: -1,
+			fails:       true,
+		},
+		{
+			name:        "too few healthy members remaining",
+			members:     []string{"a", "b", "c", "d", "e"},
+			healthy:     []string{"a", "b"},
+			candidates:  []string{"e"},
+			maxRemovals: 1,
+			fails:       true,
+		},
+		{
+			name:        "enough healthy members remaining",
+			members:     []string{"a", "b", "c", "d", "e"},
+			healthy:     []string{"a", "b", "c"},
+			candidates:  []string{"e"},
+			maxRemovals: 1,
+			removals:    []string{"e"},
+		},
+	}
+
+	for _, test := range tests {
+		restore := stubEtcdMemberHealth(test.healthy...)
+
+		terminating := make(map[string]bool)
+		for _, id := range test.terminating {
+			terminating[id] = true
+		}
+
+		removals, err := safeRemovals(etcdMembersWithIDs(test.members...), etcdMembersWithIDs(test.candidates...), terminating, test.maxRemovals)
+		restore()
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, removed %v", test.name, etcdMemberIDs(removals))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		expected := test.removals
+		if expected == nil {
+			expected = []string{}
+		}
+		if actual := etcdMemberIDs(removals); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: removed %v, expected %v", test.name, actual, expected)
+		}
+	}
+}
@@ -2,18 +2,23 @@ package command
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/coreos/etcd/client"
 	"github.com/glerchundi/infra-helper/providers"
-	"github.com/glerchundi/infra-helper/providers/aws"
 	"github.com/glerchundi/infra-helper/util"
 )
 
@@ -21,194 +26,843 @@ func NewSyncEtcdPeersCommand() cli.Command {
 	return cli.Command{
 		Name:  "sync-etcd-peers",
 		Usage: `syncs "etcd" cluster (adds/removes members based on 'autoscale' information)`,
-		Flags: []cli.Flag {
+		Flags: append([]cli.Flag {
 			cli.StringFlag{
 				Name: "out, o",
 				Value: "/etc/sysconfig/etcd-peers",
 				Usage: "etcd peers environment file destination",
 			},
-		},
+			cli.BoolFlag{
+				Name: "watch, w",
+				Usage: "keep running and periodically reconcile etcd members with 'autoscale' information",
+			},
+			cli.DurationFlag{
+				Name: "interval, i",
+				Value: 30 * time.Second,
+				Usage: "time between reconciliations when watching",
+			},
+			cli.IntFlag{
+				Name: "max-removals",
+				Value: 1,
+				Usage: "maximum number of etcd members removed per run (-1 means unlimited)",
+			},
+			cli.StringFlag{
+				Name: "data-dir",
+				Value: "/var/lib/etcd2",
+				Usage: "etcd data directory, used to tell whether an existing member lost its data",
+				EnvVar: "ETCD_DATA_DIR",
+			},
+			cli.StringFlag{
+				Name: "rejoin-strategy",
+				Value: RejoinReplace,
+				Usage: "what to do when this instance is already a member without data (replace, keep)",
+			},
+			cli.DurationFlag{
+				Name: "unstarted-timeout",
+				Value: 10 * time.Minute,
+				Usage: "time after being added an unstarted etcd member is removed (0 only removes the ones of no instance)",
+			},
+			cli.BoolFlag{
+				Name: "dry-run",
+				Usage: "print what would be done instead of changing etcd members and writing the environment file",
+			},
+		}, concatFlags(lockFlags(), memberFilterFlags(), addressingFlags(), endpointsFlags())...),
 		Action: handleSyncEtcdPeers,
 	}
 }
 
+const (
+	// RejoinReplace removes the member of this instance and adds it again
+	// as a new one, RejoinKeep leaves its membership untouched.
+	RejoinReplace = "replace"
+	RejoinKeep    = "keep"
+)
+
+type syncOptions struct {
+	maxRemovals      int
+	dryRun           bool
+	dataDir          string
+	rejoinStrategy   string
+	unstartedTimeout time.Duration
+	lockKey          string
+	lockTTL          time.Duration
+	lockTimeout      time.Duration
+	filter           memberFilter
+	addressing       memberAddressing
+	endpoints        etcdEndpoints
+}
+
 func handleSyncEtcdPeers(c *cli.Context) {
 	environmentFilePath := c.String("out")
-	if _, err := os.Stat(environmentFilePath); err == nil {
-		log.Printf("etcd-peers file %s already created, exiting.\n", environmentFilePath)
-		return
+	watch := c.Bool("watch")
+	interval := c.Duration("interval")
+	addressing, err := newMemberAddressing(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	endpoints, err := newEtcdEndpoints(c)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	rejoinStrategy := c.String("rejoin-strategy")
+	if rejoinStrategy != RejoinReplace && rejoinStrategy != RejoinKeep {
+		log.Fatalf("unknown rejoin strategy: %s", rejoinStrategy)
+	}
+
+	options := syncOptions{
+		maxRemovals:      c.Int("max-removals"),
+		dryRun:           c.Bool("dry-run"),
+		dataDir:          c.String("data-dir"),
+		rejoinStrategy:   rejoinStrategy,
+		unstartedTimeout: c.Duration("unstarted-timeout"),
+		lockKey:          c.String("lock-key"),
+		lockTTL:          c.Duration("lock-ttl"),
+		lockTimeout:      c.Duration("lock-timeout"),
+		filter:           newMemberFilter(c),
+		addressing:       addressing,
+		endpoints:        endpoints,
+	}
+
+	provider, err := newProvider(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if options.dryRun {
+		// print the plan no matter the environment file exists or not
+		if err := writeEnvironment(os.Stdout, provider, options); err != nil {
+			log.Fatal(err)
+		}
+	} else if _, err := os.Stat(environmentFilePath); err == nil {
+		if !watch {
+			log.Printf("etcd-peers file %s already created, exiting.\n", environmentFilePath)
+			return
+		}
+		log.Printf("etcd-peers file %s already created.\n", environmentFilePath)
+	} else {
+		if err := writeEnvironmentFile(environmentFilePath, provider, options); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if watch {
+		watchEtcdPeers(provider, interval, options)
+	}
+
+	return
+}
+
+func writeEnvironmentFile(environmentFilePath string, provider providers.Provider, options syncOptions) error {
 	tempFilePath := environmentFilePath + ".tmp"
 	tempFile, err := os.Create(tempFilePath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	isClosed := false
 	defer func() { if !isClosed { tempFile.Close() } }()
 
-	if err := writeEnvironment(tempFile); err != nil {
-		log.Fatal(err)
+	if err := writeEnvironment(tempFile, provider, options); err != nil {
+		return err
 	}
 
 	if err := tempFile.Close(); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	isClosed = true
 
-	if err := os.Rename(tempFilePath, environmentFilePath); err != nil {
-		log.Fatal(err)
-	}
-
-	return
+	return os.Rename(tempFilePath, environmentFilePath)
 }
 
-func writeEnvironment(w io.Writer) error {
-	var buffer bytes.Buffer
-	var err error
-	var provider providers.Provider = aws.New()
+func watchEtcdPeers(provider providers.Provider, interval time.Duration, options syncOptions) {
+	log.Printf("watching etcd members every %s\n", interval)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
+	for {
+		if err := reconcileEtcdPeers(provider, options); err != nil {
+			log.Printf("unable to reconcile etcd members: %v\n", err)
+		}
+
+		select {
+		case sig := <-signals:
+			log.Printf("received %s, stopping.\n", sig)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcileEtcdPeers removes etcd members which are no longer backed by an
+// 'autoscale' instance and reports instances which are not etcd members yet.
+func reconcileEtcdPeers(provider providers.Provider, options syncOptions) error {
 	instanceId, err := provider.GetInstanceId()
 	if err != nil {
 		return err
 	}
 
-	instanceIp, err := provider.GetInstancePrivateAddress()
+	clusterMembers, err := getClusterMembers(provider, "", options.filter, instanceId)
 	if err != nil {
 		return err
 	}
+	clusterMembersByName := clusterMembers.byName
+
+	etcdMembers, goodEtcdClientURL := listEtcdMembers(clusterMembers.all, "", options)
+	if etcdMembers == nil {
+		return errors.New("unable to reach any etcd member")
+	}
 
-	clusterMembersByName, err := provider.GetClusterMembers()
+	err = withJoinLock(goodEtcdClientURL, reconcileLockHolder(instanceId), options, func() error {
+		// members may have changed while waiting for the lock
+		etcdMembers, err = util.EtcdListMembers(goodEtcdClientURL)
+		if err != nil {
+			return err
+		}
+
+		badPeers, err := findBadPeers(goodEtcdClientURL, etcdMembers, clusterMembers, nil, options)
+		if err != nil {
+			return err
+		}
+
+		if options.dryRun {
+			for _, etcdMember := range badPeers {
+				log.Printf("would remove etcd member: %s%s\n", etcdMember.ID, unstartedSuffix(etcdMember))
+			}
+			return nil
+		}
+
+		return removeEtcdMembers(goodEtcdClientURL, badPeers)
+	})
 	if err != nil {
 		return err
 	}
 
-	// retrieve current cluster members
-	var etcdMembers []client.Member
-	var goodEtcdClientURL string = ""
-	for _, memberIp := range clusterMembersByName {
-		if memberIp == instanceIp {
+	// report instances not being part of the cluster
+	peerHosts := make([]string, 0)
+	for _, etcdMember := range etcdMembers {
+		peerHost, err := etcdMemberPeerHost(etcdMember)
+		if err != nil {
+			return err
+		}
+		peerHosts = append(peerHosts, peerHost)
+	}
+
+	for memberName, member := range clusterMembersByName {
+		host, err := options.addressing.host(member)
+		if err != nil {
+			log.Printf("skipping instance %s: %v\n", memberName, err)
+			continue
+		}
+
+		isEtcdMember := false
+		for _, peerHost := range peerHosts {
+			if sameHost(peerHost, host) {
+				isEtcdMember = true
+				break
+			}
+		}
+		if !isEtcdMember {
+			log.Printf("instance %s (%s) is not an etcd member\n", memberName, host)
+		}
+	}
+
+	return nil
+}
+
+// listEtcdMembers returns the etcd members reported by the first reachable
+// cluster member, skipping the one with the given name, and its client url.
+func listEtcdMembers(clusterMembersByName map[string]providers.Member, skipName string, options syncOptions) ([]client.Member, string) {
+	for memberName, member := range clusterMembersByName {
+		if memberName == skipName {
+			continue
+		}
+
+		host, err := options.addressing.host(member)
+		if err != nil {
+			log.Printf("unable to reach instance %s: %v\n", memberName, err)
 			continue
 		}
 
-		etcdClientURL := util.EtcdClientURLFromIP(memberIp)
-		etcdMembers, err = util.EtcdListMembers(etcdClientURL)
+		etcdClientURL := options.endpoints.clientURL(host)
+		etcdMembers, err := util.EtcdListMembers(etcdClientURL)
 		if err == nil {
-			goodEtcdClientURL = etcdClientURL
-			break
+			return etcdMembers, etcdClientURL
 		}
 	}
 
-	// etcd parameters
-	var initialClusterState string
-	var initialCluster string
+	return nil, ""
+}
 
-	// check if instanceId is already member of cluster
-	var isMember bool = false
-	for _, member := range etcdMembers {
-		if member.Name == instanceId {
-			isMember = true
+// findBadPeers returns every etcd member whose peer host doesn't belong to
+// any of the cluster members, the stale unstarted ones and the replaced ones,
+// as long as it is safe to remove them. Members being terminated, replaced or
+// stale are removed even if they are still healthy.
+func findBadPeers(etcdClientURL string, etcdMembers []client.Member, clusterMembers *clusterMembers, replaced []client.Member, options syncOptions) ([]client.Member, error) {
+	clusterMembersByName := clusterMembers.byName
+
+	// an empty listing is never trustworthy
+	if len(clusterMembersByName) == 0 {
+		return nil, errors.New("no cluster members found, refusing to remove etcd members")
+	}
+
+	// hosts identifying the cluster members in etcd
+	hosts := memberHosts(clusterMembersByName, options.addressing)
+	terminatingHosts := memberHosts(clusterMembers.terminating, options.addressing)
+
+	isReplaced := make(map[string]bool)
+	for _, etcdMember := range replaced {
+		isReplaced[etcdMember.ID] = true
+	}
+
+	candidates := make([]client.Member, 0)
+	terminating := make(map[string]bool)
+	for _, etcdMember := range etcdMembers {
+		// unstarted members are removed once stale, whatever the state of
+		// the instance they belong to
+		if etcdMember.Name == "" && !isReplaced[etcdMember.ID] {
+			isStale, err := isStaleUnstartedMember(etcdClientURL, etcdMember, clusterMembers.all, options)
+			if err != nil {
+				return nil, err
+			}
+			if isStale {
+				candidates = append(candidates, etcdMember)
+				terminating[etcdMember.ID] = true
+			}
+			continue
+		}
+
+		peerHost, err := etcdMemberPeerHost(etcdMember)
+		if err != nil {
+			return nil, err
+		}
+
+		if isReplaced[etcdMember.ID] || !containsHost(hosts, peerHost) {
+			candidates = append(candidates, etcdMember)
+			terminating[etcdMember.ID] = isReplaced[etcdMember.ID] || containsHost(terminatingHosts, peerHost)
+		}
+	}
+
+	return safeRemovals(etcdMembers, candidates, terminating, options.maxRemovals)
+}
+
+const (
+	// etcdMemberAddedKeyPrefix is the prefix of the etcd keys recording when
+	// each member was added, they expire long after the unstarted timeout.
+	etcdMemberAddedKeyPrefix = "/infra-helper/added-members/"
+	etcdMemberAddedKeyTTL    = 24 * time.Hour
+)
+
+// recordEtcdMemberAdded records the time the given member was added at,
+// unless it is already recorded.
+func recordEtcdMemberAdded(etcdClientURL, id string, at time.Time) error {
+	return util.EtcdCreateKey(etcdClientURL, etcdMemberAddedKeyPrefix+id, at.UTC().Format(time.RFC3339), etcdMemberAddedKeyTTL)
+}
+
+// etcdMemberAddedAt returns the time the given member was added at. Members
+// added by someone else are recorded as added now, unless on a dry run.
+func etcdMemberAddedAt(etcdClientURL, id string, dryRun bool) (time.Time, error) {
+	value, err := util.EtcdGetKey(etcdClientURL, etcdMemberAddedKeyPrefix+id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if value == "" {
+		now := time.Now()
+		if dryRun {
+			return now, nil
+		}
+		return now, recordEtcdMemberAdded(etcdClientURL, id, now)
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// isStaleUnstartedMember tells whether the given member, which was added but
+// never started (it has no name yet), was added longer than the unstarted
+// timeout ago, probably by a join which failed. If the timeout is disabled
+// only members belonging to no instance at all are stale.
+func isStaleUnstartedMember(etcdClientURL string, etcdMember client.Member, allClusterMembers map[string]providers.Member, options syncOptions) (bool, error) {
+	peerHost, err := etcdMemberPeerHost(etcdMember)
+	if err != nil {
+		return false, err
+	}
+
+	var owner *providers.Member
+	for _, member := range allClusterMembers {
+		host, err := options.addressing.host(member)
+		if err != nil {
+			continue
+		}
+		if sameHost(host, peerHost) {
+			owner = &member
 			break
 		}
 	}
 
-	// if i am not already listed as a member of the cluster assume that this is a new cluster
-	if etcdMembers != nil && !isMember {
-		log.Printf("joining to an existing cluster, using this client url: %s\n", goodEtcdClientURL)
+	if options.unstartedTimeout <= 0 {
+		if owner == nil {
+			log.Printf("etcd member %s (%s) never started and belongs to no instance\n", etcdMember.ID, peerHost)
+		}
+		return owner == nil, nil
+	}
 
-		//
-		// detect and remove bad peers
-		//
+	addedAt, err := etcdMemberAddedAt(etcdClientURL, etcdMember.ID, options.dryRun)
+	if err != nil {
+		return false, err
+	}
+
+	if time.Since(addedAt) <= options.unstartedTimeout {
+		return false, nil
+	}
 
-		// create a reverse cluster members map
-		clusterMembersByIp := make(map[string]string)
-		for memberName, memberIp := range clusterMembersByName {
-			clusterMembersByIp[memberIp] = memberName
+	if owner == nil {
+		log.Printf("etcd member %s (%s) never started since added at %s and belongs to no instance\n", etcdMember.ID, peerHost, addedAt)
+	} else {
+		log.Printf("etcd member %s of instance %s never started since added at %s\n", etcdMember.ID, owner.Id, addedAt)
+	}
+
+	return true, nil
+}
+
+// memberHosts returns the host of every given member, the ones lacking it
+// (i.e. an address not assigned yet) are skipped.
+func memberHosts(members map[string]providers.Member, addressing memberAddressing) []string {
+	hosts := make([]string, 0)
+	for memberName, member := range members {
+		host, err := addressing.host(member)
+		if err != nil {
+			log.Printf("skipping instance %s: %v\n", memberName, err)
+			continue
 		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
 
-		for _, etcdMember := range etcdMembers {
-			peerURL, err := url.Parse(etcdMember.PeerURLs[0])
-			if err != nil {
-				return err
-			}
+func containsHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if sameHost(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeEtcdMembers(etcdClientURL string, etcdMembers []client.Member) error {
+	for _, etcdMember := range etcdMembers {
+		log.Printf("removing etcd member: %s...", etcdMember.ID)
+		if err := util.EtcdRemoveMember(etcdClientURL, etcdMember.ID); err != nil {
+			return err
+		}
+		log.Printf("done\n")
+	}
 
-			peerHost, _, err := net.SplitHostPort(peerURL.Host)
+	return nil
+}
+
+func etcdMemberPeerHost(etcdMember client.Member) (string, error) {
+	if len(etcdMember.PeerURLs) == 0 {
+		return "", fmt.Errorf("etcd member %s has no peer urls", etcdMember.ID)
+	}
+
+	peerURL, err := url.Parse(etcdMember.PeerURLs[0])
+	if err != nil {
+		return "", err
+	}
+
+	peerHost, _, err := net.SplitHostPort(peerURL.Host)
+	if err != nil {
+		return "", err
+	}
+
+	return peerHost, nil
+}
+
+// hasEtcdData tells whether the data directory holds a write ahead log,
+// either in the etcd 2.0 or in the later member/ layout.
+func hasEtcdData(dataDir string) bool {
+	for _, walDir := range []string{filepath.Join(dataDir, "member", "wal"), filepath.Join(dataDir, "wal")} {
+		names, err := ioutil.ReadDir(walDir)
+		if err == nil && len(names) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// syncPlan describes what sync-etcd-peers is going to do against the
+// cluster and which environment it is going to write.
+type syncPlan struct {
+	EtcdClientURL       string
+	Name                string
+	Removals            []client.Member
+	AdditionPeerURL     string
+	InitialClusterState string
+	InitialCluster      string
+}
+
+func writeEnvironment(w io.Writer, provider providers.Provider, options syncOptions) error {
+	plan, err := planEnvironment(provider, options)
+	if err != nil {
+		return err
+	}
+
+	if options.dryRun {
+		return printPlan(w, plan, options)
+	}
+
+	// a new cluster has nowhere to lock on
+	if plan.EtcdClientURL == "" {
+		err = applyPlan(plan)
+	} else {
+		// the lock is held until the added member starts, otherwise the
+		// next instance would join without it in its initial cluster
+		err = withHeldJoinLock(plan.EtcdClientURL, lockHolder(plan.Name), options, func() (bool, error) {
+			// the cluster may have changed while waiting for the lock
+			plan, err = planEnvironment(provider, options)
 			if err != nil {
-				return err
+				return false, err
 			}
+			return plan.AdditionPeerURL != "", applyPlan(plan)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// indicate it's going to write envvars
+	log.Printf("writing environment variables...")
+
+	if err := writeEnvironmentVariables(w, plan, options); err != nil {
+		return err
+	}
+
+	// write done
+	log.Printf("done\n")
+
+	return nil
+}
 
-			if _, ok := clusterMembersByIp[peerHost]; !ok {
-				log.Printf("removing etcd member: %s...", etcdMember.ID)
-				err = util.EtcdRemoveMember(goodEtcdClientURL, etcdMember.ID)
-				if err != nil {
-					return err
+func lockFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name: "lock-key",
+			Value: "/infra-helper/join-lock",
+			Usage: "etcd key serializing member changes across instances (empty disables locking)",
+		},
+		cli.DurationFlag{
+			Name: "lock-ttl",
+			Value: 5 * time.Minute,
+			Usage: "time after which the lock is released if its holder dies",
+		},
+		cli.DurationFlag{
+			Name: "lock-timeout",
+			Value: 10 * time.Minute,
+			Usage: "maximum time to wait for the lock",
+		},
+	}
+}
+
+// lockHolder returns the holder name of the lock taken by this process on
+// behalf of the given instance. It is unique per process, as the lock is
+// taken over by a holder of the same name.
+func lockHolder(instanceId string) string {
+	return fmt.Sprintf("%s/%d", instanceId, os.Getpid())
+}
+
+// reconcileLockHolder returns the holder name of the lock taken by the watch
+// loop of this process. It differs from the one of lockHolder, so the lock
+// kept after adding the member of this instance is neither taken over nor
+// released by the loop, which waits for it instead.
+func reconcileLockHolder(instanceId string) string {
+	return lockHolder(instanceId + "@watch")
+}
+
+// withJoinLock runs fn holding the lock which serializes member changes of
+// the cluster reachable at the given client url, unless locking is disabled
+// or nothing is going to be changed.
+func withJoinLock(etcdClientURL, holder string, options syncOptions, fn func() error) error {
+	return withHeldJoinLock(etcdClientURL, holder, options, func() (bool, error) {
+		return false, fn()
+	})
+}
+
+// withHeldJoinLock is like withJoinLock but the lock is kept if fn succeeds
+// and tells so. It is released by releaseInstanceJoinLock or, if nobody does,
+// once its TTL expires.
+func withHeldJoinLock(etcdClientURL, holder string, options syncOptions, fn func() (bool, error)) error {
+	if options.lockKey == "" || options.dryRun {
+		_, err := fn()
+		return err
+	}
+
+	lock, err := util.NewEtcdLock(etcdClientURL, options.lockKey, holder, options.lockTTL)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("acquiring lock %s...", options.lockKey)
+	if err := lock.Lock(options.lockTimeout); err != nil {
+		return err
+	}
+	log.Printf("done\n")
+
+	keep, err := fn()
+	if keep && err == nil {
+		log.Printf("keeping lock %s until the new member starts, at most %s\n", options.lockKey, options.lockTTL)
+		return nil
+	}
+
+	if err := lock.Unlock(); err != nil {
+		log.Printf("unable to release lock %s: %v\n", options.lockKey, err)
+	}
+
+	return err
+}
+
+// releaseInstanceJoinLock releases the lock if it is held on behalf of the
+// given instance by any process, i.e. kept by sync-etcd-peers after adding
+// its member.
+func releaseInstanceJoinLock(etcdClientURL, instanceId string, options syncOptions) {
+	if options.lockKey == "" || options.dryRun {
+		return
+	}
+
+	lock, err := util.NewEtcdLock(etcdClientURL, options.lockKey, "", options.lockTTL)
+	if err == nil {
+		var released bool
+		released, err = lock.UnlockIfHeldBy(instanceId + "/")
+		if released {
+			log.Printf("released lock %s\n", options.lockKey)
+		}
+	}
+	if err != nil {
+		log.Printf("unable to release lock %s: %v\n", options.lockKey, err)
+	}
+}
+
+// getSelfHost returns the host of this instance as listed by the provider.
+// If it is not listed yet only its private ip is known, which is enough for
+// ipv4 addressing only.
+func getSelfHost(provider providers.Provider, instanceId string, allClusterMembers map[string]providers.Member, addressing memberAddressing) (string, error) {
+	if self, ok := allClusterMembers[instanceId]; ok {
+		return addressing.host(self)
+	}
+
+	if addressing.strategy != AddressingIPv4 {
+		return "", fmt.Errorf("instance %s is not listed by the provider, unable to tell its %s host", instanceId, addressing.strategy)
+	}
+
+	return provider.GetInstancePrivateAddress()
+}
+
+func planEnvironment(provider providers.Provider, options syncOptions) (*syncPlan, error) {
+	instanceId, err := provider.GetInstanceId()
+	if err != nil {
+		return nil, err
+	}
+
+	clusterMembers, err := getClusterMembers(provider, "", options.filter, instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	selfHost, err := getSelfHost(provider, instanceId, clusterMembers.all, options.addressing)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve current cluster members, asking any instance as one filtered
+	// out may be serving them
+	etcdMembers, goodEtcdClientURL := listEtcdMembers(clusterMembers.all, instanceId, options)
+
+	plan := &syncPlan{
+		EtcdClientURL: goodEtcdClientURL,
+		Name:          instanceId,
+	}
+
+	// check if instanceId is already member of cluster
+	var selfEtcdMember *client.Member
+	for i, member := range etcdMembers {
+		if member.Name == instanceId {
+			selfEtcdMember = &etcdMembers[i]
+			break
+		}
+	}
+
+	if etcdMembers != nil && selfEtcdMember != nil {
+		// the member keeps its identity as long as its data is there
+		if options.rejoinStrategy == RejoinKeep || hasEtcdData(options.dataDir) {
+			log.Printf("already a member of the cluster, keeping its configuration\n")
+
+			kvs := make([]string, 0)
+			for _, etcdMember := range etcdMembers {
+				// ignore unstarted peers
+				if len(etcdMember.Name) == 0 {
+					continue
 				}
-				log.Printf("done\n")
+				kvs = append(kvs, fmt.Sprintf("%s=%s", etcdMember.Name, etcdMember.PeerURLs[0]))
 			}
+
+			plan.InitialClusterState = "existing"
+			plan.InitialCluster = strings.Join(kvs, ",")
+
+			return plan, nil
 		}
 
+		log.Printf("already a member of the cluster without data in %s, replacing it\n", options.dataDir)
+	}
+
+	// if i am not already listed as a member of the cluster assume that this is a new cluster
+	if etcdMembers != nil {
+		log.Printf("joining to an existing cluster, using this client url: %s\n", goodEtcdClientURL)
+
 		//
-		// list current etcd members (after removing spurious ones)
+		// detect bad peers
 		//
 
-		etcdMembers, err = util.EtcdListMembers(goodEtcdClientURL)
+		// a stale member of this instance is replaced by a new one
+		replaced := make([]client.Member, 0)
+		if selfEtcdMember != nil {
+			replaced = append(replaced, *selfEtcdMember)
+		}
+
+		plan.Removals, err = findBadPeers(goodEtcdClientURL, etcdMembers, clusterMembers, replaced, options)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		isRemoval := make(map[string]bool)
+		for _, etcdMember := range plan.Removals {
+			isRemoval[etcdMember.ID] = true
 		}
 
+		//
+		// current etcd members (after removing spurious ones)
+		//
+
 		kvs := make([]string, 0)
 		for _, etcdMember := range etcdMembers {
-			// ignore unstarted peers
-			if len(etcdMember.Name) == 0 {
+			// ignore removed and unstarted peers
+			if isRemoval[etcdMember.ID] || len(etcdMember.Name) == 0 {
 				continue
 			}
 			kvs = append(kvs, fmt.Sprintf("%s=%s", etcdMember.Name, etcdMember.PeerURLs[0]))
 		}
-		kvs = append(kvs, fmt.Sprintf("%s=%s", instanceId, util.EtcdPeerURLFromIP(instanceIp)))
+		kvs = append(kvs, fmt.Sprintf("%s=%s", instanceId, options.endpoints.peerURL(selfHost)))
 
-		initialClusterState = "existing"
-		initialCluster = strings.Join(kvs, ",")
+		plan.InitialClusterState = "existing"
+		plan.InitialCluster = strings.Join(kvs, ",")
 
 		//
-		// join an existing cluster
+		// join an existing cluster, unless a previous attempt already added
+		// this instance and it is not stale yet
 		//
 
-		instancePeerURL := util.EtcdPeerURLFromIP(instanceIp)
-		log.Printf("adding etcd member: %s...", instancePeerURL)
-		member, err := util.EtcdAddMember(goodEtcdClientURL, instancePeerURL)
-		if member == nil {
-			return err
+		pending, err := findEtcdMemberByHost(etcdMembers, selfHost)
+		if err == nil && pending.Name == "" && !isRemoval[pending.ID] {
+			log.Printf("etcd member %s of this instance already added, waiting for it to start\n", pending.ID)
+		} else {
+			plan.AdditionPeerURL = options.endpoints.peerURL(selfHost)
 		}
-		log.Printf("done\n")
 	} else {
 		log.Printf("creating new cluster\n")
 
-		// initial cluster
+		// initial cluster, every instance but the terminating ones must be
+		// listed no matter its state, as the others may see it differently
 		kvs := make([]string, 0)
-		for memberName, memberIp := range clusterMembersByName {
-			kvs = append(kvs, fmt.Sprintf("%s=%s", memberName, util.EtcdPeerURLFromIP(memberIp)))
+		for memberName, member := range clusterMembers.all {
+			if _, ok := clusterMembers.terminating[memberName]; ok {
+				continue
+			}
+			host, err := options.addressing.host(member)
+			if err != nil {
+				return nil, err
+			}
+			kvs = append(kvs, fmt.Sprintf("%s=%s", memberName, options.endpoints.peerURL(host)))
+		}
+		if _, ok := clusterMembers.all[instanceId]; !ok {
+			kvs = append(kvs, fmt.Sprintf("%s=%s", instanceId, options.endpoints.peerURL(selfHost)))
 		}
 
-		initialClusterState = "new"
-		initialCluster = strings.Join(kvs, ",")
+		plan.InitialClusterState = "new"
+		plan.InitialCluster = strings.Join(kvs, ",")
 	}
 
-	// indicate it's going to write envvars
-	log.Printf("writing environment variables...")
+	return plan, nil
+}
+
+func applyPlan(plan *syncPlan) error {
+	if err := removeEtcdMembers(plan.EtcdClientURL, plan.Removals); err != nil {
+		return err
+	}
+
+	if plan.AdditionPeerURL != "" {
+		log.Printf("adding etcd member: %s...", plan.AdditionPeerURL)
+		member, err := util.EtcdAddMember(plan.EtcdClientURL, plan.AdditionPeerURL)
+		if member == nil {
+			return err
+		}
+		log.Printf("done\n")
+
+		// tells it apart from stale unstarted members until it starts
+		if err := recordEtcdMemberAdded(plan.EtcdClientURL, member.ID, time.Now()); err != nil {
+			log.Printf("unable to record etcd member %s addition: %v\n", member.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func writeEnvironmentVariables(w io.Writer, plan *syncPlan, options syncOptions) error {
+	var buffer bytes.Buffer
 
 	// create environment variables
-	buffer.WriteString(fmt.Sprintf("ETCD_NAME=%s\n", instanceId))
-	buffer.WriteString(fmt.Sprintf("ETCD_INITIAL_CLUSTER_STATE=%s\n", initialClusterState))
-	buffer.WriteString(fmt.Sprintf("ETCD_INITIAL_CLUSTER=%s\n", initialCluster))
+	buffer.WriteString(fmt.Sprintf("ETCD_NAME=%s\n", plan.Name))
+	buffer.WriteString(fmt.Sprintf("ETCD_INITIAL_CLUSTER_STATE=%s\n", plan.InitialClusterState))
+	buffer.WriteString(fmt.Sprintf("ETCD_INITIAL_CLUSTER=%s\n", plan.InitialCluster))
+	options.endpoints.writePeerTLSVariables(&buffer)
+
+	_, err := buffer.WriteTo(w)
+	return err
+}
+
+func printPlan(w io.Writer, plan *syncPlan, options syncOptions) error {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("# members to remove:\n")
+	if len(plan.Removals) == 0 {
+		buffer.WriteString("#   none\n")
+	}
+	for _, etcdMember := range plan.Removals {
+		buffer.WriteString(fmt.Sprintf("#   %s %s %s%s\n", etcdMember.ID, etcdMember.Name, strings.Join(etcdMember.PeerURLs, ","), unstartedSuffix(etcdMember)))
+	}
+
+	buffer.WriteString("# member to add:\n")
+	if plan.AdditionPeerURL == "" {
+		buffer.WriteString("#   none\n")
+	} else {
+		buffer.WriteString(fmt.Sprintf("#   %s %s\n", plan.Name, plan.AdditionPeerURL))
+	}
 
 	if _, err := buffer.WriteTo(w); err != nil {
 		return err
 	}
 
-	// write done
-	log.Printf("done\n")
+	return writeEnvironmentVariables(w, plan, options)
+}
 
-	return nil
-}
\ No newline at end of file
+func unstartedSuffix(etcdMember client.Member) string {
+	if etcdMember.Name == "" {
+		return " (unstarted)"
+	}
+	return ""
+}
@@ -0,0 +1,589 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+// the cluster of the tests, only n1 serves the etcd client api (at the port
+// of the fake etcd) and n3 is this instance
+var testAddresses = map[string]string{"n1": "127.0.0.1", "n2": "127.0.0.2", "n3": "127.0.0.3"}
+
+func TestPlanEnvironmentNewCluster(t *testing.T) {
+	server, _ := newFakeEtcd(t)
+	options := testSyncOptions(t, server)
+	server.Close()
+
+	plan, err := planEnvironment(newStaticProvider(t, "n3", testAddresses), options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"n1=http://127.0.0.1:2380", "n2=http://127.0.0.2:2380", "n3=http://127.0.0.3:2380"}
+	if plan.InitialClusterState != "new" || !reflect.DeepEqual(sortedList(plan.InitialCluster), expected) ||
+		plan.AdditionPeerURL != "" || len(plan.Removals) != 0 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestPlanEnvironmentNewClusterUnfiltered(t *testing.T) {
+	server, _ := newFakeEtcd(t)
+	options := testSyncOptions(t, server)
+	server.Close()
+
+	options.filter = memberFilter{lifecycleStates: []string{"InService"}, healthStatuses: []string{"Healthy"}}
+	provider := &fakeProvider{self: "n3", members: map[string]providers.Member{
+		"n1": {Id: "n1", PrivateAddress: "127.0.0.1", LifecycleState: "InService", HealthStatus: "Healthy"},
+		"n2": {Id: "n2", PrivateAddress: "127.0.0.2", LifecycleState: "Pending", HealthStatus: "Healthy"},
+		"n3": {Id: "n3", PrivateAddress: "127.0.0.3", LifecycleState: "Pending", HealthStatus: "Unhealthy"},
+		"n4": {Id: "n4", PrivateAddress: "127.0.0.4", LifecycleState: "Terminating:Wait", HealthStatus: "Healthy"},
+	}}
+
+	// instances launched together must agree on the initial cluster no
+	// matter how far each one got
+	plan, err := planEnvironment(provider, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"n1=http://127.0.0.1:2380", "n2=http://127.0.0.2:2380", "n3=http://127.0.0.3:2380"}
+	if plan.InitialClusterState != "new" || !reflect.DeepEqual(sortedList(plan.InitialCluster), expected) {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestPlanEnvironmentJoin(t *testing.T) {
+	server, _ := newFakeEtcd(t,
+		etcdMember("m1", "n1", "127.0.0.1"),
+		etcdMember("m2", "n2", "127.0.0.2"),
+		etcdMember("old", "n0", "127.0.0.9"),
+	)
+	defer server.Close()
+	defer stubEtcdMemberHealth("m1", "m2")()
+
+	plan, err := planEnvironment(newStaticProvider(t, "n3", testAddresses), testSyncOptions(t, server))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"n1=http://127.0.0.1:2380", "n2=http://127.0.0.2:2380", "n3=http://127.0.0.3:2380"}
+	if plan.InitialClusterState != "existing" || !reflect.DeepEqual(sortedList(plan.InitialCluster), expected) ||
+		plan.AdditionPeerURL != "http://127.0.0.3:2380" || !reflect.DeepEqual(etcdMemberIDs(plan.Removals), []string{"old"}) {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestPlanEnvironmentRejoin(t *testing.T) {
+	server, _ := newFakeEtcd(t,
+		etcdMember("m1", "n1", "127.0.0.1"),
+		etcdMember("m2", "n2", "127.0.0.2"),
+		etcdMember("m3", "n3", "127.0.0.3"),
+	)
+	defer server.Close()
+	defer stubEtcdMemberHealth("m1", "m2", "m3")()
+
+	provider := newStaticProvider(t, "n3", testAddresses)
+	options := testSyncOptions(t, server)
+	expected := []string{"n1=http://127.0.0.1:2380", "n2=http://127.0.0.2:2380", "n3=http://127.0.0.3:2380"}
+
+	// without data the member is replaced
+	plan, err := planEnvironment(provider, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.InitialClusterState != "existing" || !reflect.DeepEqual(sortedList(plan.InitialCluster), expected) ||
+		plan.AdditionPeerURL != "http://127.0.0.3:2380" || !reflect.DeepEqual(etcdMemberIDs(plan.Removals), []string{"m3"}) {
+		t.Errorf("unexpected plan without data: %+v", plan)
+	}
+
+	// with data it is kept
+	walDir := filepath.Join(options.dataDir, "member", "wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(walDir, "0.wal"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = planEnvironment(provider, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.InitialClusterState != "existing" || !reflect.DeepEqual(sortedList(plan.InitialCluster), expected) ||
+		plan.AdditionPeerURL != "" || len(plan.Removals) != 0 {
+		t.Errorf("unexpected plan with data: %+v", plan)
+	}
+}
+
+func TestFindBadPeers(t *testing.T) {
+	defer stubEtcdMemberHealth("m1", "m2", "m3")()
+
+	clusterMembers, err := getClusterMembers(newStaticProvider(t, "n3", testAddresses), "", memberFilter{}, "n3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etcdMembers := []client.Member{
+		etcdMember("m1", "n1", "127.0.0.1"),
+		etcdMember("m2", "n2", "127.0.0.2"),
+		etcdMember("m3", "n3", "127.0.0.3"),
+		etcdMember("x", "n8", "127.0.0.8"),
+		etcdMember("y", "n9", "127.0.0.9"),
+	}
+
+	options := syncOptions{maxRemovals: 1, addressing: memberAddressing{strategy: AddressingIPv4}}
+	if removals, err := findBadPeers("", etcdMembers, clusterMembers, nil, options); err == nil {
+		t.Errorf("expected an error exceeding max removals, removed %v", etcdMemberIDs(removals))
+	}
+
+	options.maxRemovals = -1
+	removals, err := findBadPeers("", etcdMembers, clusterMembers, nil, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual := etcdMemberIDs(removals); !reflect.DeepEqual(actual, []string{"x", "y"}) {
+		t.Errorf("removed %v, expected [x y]", actual)
+	}
+
+	// an empty listing is never trustworthy
+	clusterMembers.byName = map[string]providers.Member{}
+	if _, err := findBadPeers("", etcdMembers, clusterMembers, nil, options); err == nil {
+		t.Errorf("expected an error without cluster members")
+	}
+}
+
+func TestFindBadPeersWithoutHost(t *testing.T) {
+	defer stubEtcdMemberHealth("m1", "m2")()
+
+	provider := &fakeProvider{self: "n1", members: map[string]providers.Member{
+		"n1": {Id: "n1", PrivateAddress: "10.0.0.1", IPv6Address: "fd00::1"},
+		"n2": {Id: "n2", PrivateAddress: "10.0.0.2", IPv6Address: "fd00::2"},
+		"n3": {Id: "n3", PrivateAddress: "10.0.0.3"},
+	}}
+	clusterMembers, err := getClusterMembers(provider, "", memberFilter{}, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etcdMembers := []client.Member{
+		etcdMember("m1", "n1", "fd00::1"),
+		etcdMember("m2", "n2", "fd00::2"),
+		etcdMember("x", "n9", "fd00::9"),
+	}
+
+	// n3 has no ipv6 address yet, it doesn't prevent removing x
+	options := syncOptions{maxRemovals: 1, addressing: memberAddressing{strategy: AddressingIPv6}}
+	removals, err := findBadPeers("", etcdMembers, clusterMembers, nil, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual := etcdMemberIDs(removals); !reflect.DeepEqual(actual, []string{"x"}) {
+		t.Errorf("removed %v, expected [x]", actual)
+	}
+}
+
+func TestGetSelfHost(t *testing.T) {
+	self := providers.Member{Id: "n1", PrivateAddress: "10.0.0.1", IPv6Address: "fd00::1"}
+	provider := &fakeProvider{self: "n1", members: map[string]providers.Member{"n1": self}}
+	listed := map[string]providers.Member{"n1": self}
+	unlisted := map[string]providers.Member{}
+
+	ipv4 := memberAddressing{strategy: AddressingIPv4}
+	ipv6 := memberAddressing{strategy: AddressingIPv6}
+
+	if host, err := getSelfHost(provider, "n1", listed, ipv6); err != nil || host != "fd00::1" {
+		t.Errorf("listed ipv6: got %q, %v", host, err)
+	}
+	if host, err := getSelfHost(provider, "n1", unlisted, ipv4); err != nil || host != "10.0.0.1" {
+		t.Errorf("unlisted ipv4: got %q, %v", host, err)
+	}
+	if host, err := getSelfHost(provider, "n1", unlisted, ipv6); err == nil {
+		t.Errorf("unlisted ipv6: expected an error, got %q", host)
+	}
+	if host, err := getSelfHost(provider, "n1", map[string]providers.Member{"n1": {Id: "n1", PrivateAddress: "10.0.0.1"}}, ipv6); err == nil {
+		t.Errorf("listed without ipv6: expected an error, got %q", host)
+	}
+}
+
+func TestFindBadPeersReplaced(t *testing.T) {
+	clusterMembers, err := getClusterMembers(newStaticProvider(t, "n3", testAddresses), "", memberFilter{}, "n3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		etcdMembers []client.Member
+		healthy     []string
+		maxRemovals int
+		removals    []string
+		fails       bool
+	}{
+		{
+			name: "healthy replaced member",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+			},
+			healthy:     []string{"m1", "m2", "m3"},
+			maxRemovals: 1,
+			removals:    []string{"m3"},
+		},
+		{
+			name: "replaced member no longer backed by its host is removed once",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.7"),
+			},
+			healthy:     []string{"m1", "m2"},
+			maxRemovals: 1,
+			removals:    []string{"m3"},
+		},
+		{
+			name: "replacement counts against max removals",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+				etcdMember("x", "n9", "127.0.0.9"), etcdMember("m4", "n4", "127.0.0.4"),
+			},
+			healthy:     []string{"m1", "m2", "m3", "m4"},
+			maxRemovals: 1,
+			fails:       true,
+		},
+		{
+			name: "replacement keeps quorum",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+			},
+			healthy:     []string{"m1", "m3"},
+			maxRemovals: 1,
+			fails:       true,
+		},
+	}
+
+	for _, test := range tests {
+		restore := stubEtcdMemberHealth(test.healthy...)
+
+		// the member of this instance (n3) is always the third one
+		options := syncOptions{maxRemovals: test.maxRemovals, addressing: memberAddressing{strategy: AddressingIPv4}}
+		removals, err := findBadPeers("", test.etcdMembers, clusterMembers, test.etcdMembers[2:3], options)
+		restore()
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, removed %v", test.name, etcdMemberIDs(removals))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if actual := etcdMemberIDs(removals); !reflect.DeepEqual(actual, test.removals) {
+			t.Errorf("%s: removed %v, expected %v", test.name, actual, test.removals)
+		}
+	}
+}
+
+func TestFindBadPeersUnstarted(t *testing.T) {
+	provider := &fakeProvider{self: "n3", members: map[string]providers.Member{
+		"n1": {Id: "n1", PrivateAddress: "127.0.0.1", LifecycleState: "InService"},
+		"n2": {Id: "n2", PrivateAddress: "127.0.0.2", LifecycleState: "InService"},
+		"n3": {Id: "n3", PrivateAddress: "127.0.0.3", LifecycleState: "InService", LaunchTime: time.Now().Add(-time.Hour)},
+		"n4": {Id: "n4", PrivateAddress: "127.0.0.4", LifecycleState: "Pending"},
+	}}
+	clusterMembers, err := getClusterMembers(provider, "", memberFilter{lifecycleStates: []string{"InService"}}, "n3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recently := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	longAgo := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name             string
+		etcdMembers      []client.Member
+		added            map[string]string
+		unstartedTimeout time.Duration
+		removals         []string
+		fails            bool
+	}{
+		{
+			name: "member of a long running instance rejoining",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("new", "", "127.0.0.3"),
+			},
+			added:            map[string]string{"new": recently},
+			unstartedTimeout: 10 * time.Minute,
+		},
+		{
+			name: "member of a pending instance",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+				etcdMember("new", "", "127.0.0.4"),
+			},
+			added:            map[string]string{"new": recently},
+			unstartedTimeout: 10 * time.Minute,
+		},
+		{
+			name: "member of a pending instance added long ago",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+				etcdMember("old", "", "127.0.0.4"),
+			},
+			added:            map[string]string{"old": longAgo},
+			unstartedTimeout: 10 * time.Minute,
+			removals:         []string{"old"},
+		},
+		{
+			name: "stale members count against max removals",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+				etcdMember("old", "", "127.0.0.4"), etcdMember("x", "", "127.0.0.9"),
+			},
+			added:            map[string]string{"old": longAgo, "x": longAgo},
+			unstartedTimeout: 10 * time.Minute,
+			fails:            true,
+		},
+		{
+			name: "member of no instance added by someone else",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+				etcdMember("x", "", "127.0.0.9"),
+			},
+			unstartedTimeout: 10 * time.Minute,
+		},
+		{
+			name: "member of no instance without timeout",
+			etcdMembers: []client.Member{
+				etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "n3", "127.0.0.3"),
+				etcdMember("x", "", "127.0.0.9"),
+			},
+			removals: []string{"x"},
+		},
+	}
+
+	for _, test := range tests {
+		server, etcd := newFakeEtcd(t, test.etcdMembers...)
+		for id, at := range test.added {
+			etcd.keys[etcdMemberAddedKeyPrefix+id] = at
+		}
+		restore := stubEtcdMemberHealth("m1", "m2", "m3")
+
+		options := syncOptions{maxRemovals: 1, unstartedTimeout: test.unstartedTimeout, addressing: memberAddressing{strategy: AddressingIPv4}}
+		removals, err := findBadPeers(server.URL, test.etcdMembers, clusterMembers, nil, options)
+		restore()
+		server.Close()
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, removed %v", test.name, etcdMemberIDs(removals))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if actual := etcdMemberIDs(removals); !reflect.DeepEqual(actual, append([]string{}, test.removals...)) {
+			t.Errorf("%s: removed %v, expected %v", test.name, actual, test.removals)
+		}
+
+		// members added by someone else are timed from now on
+		if test.unstartedTimeout > 0 {
+			for _, etcdMember := range test.etcdMembers {
+				if etcdMember.Name == "" && etcd.key(etcdMemberAddedKeyPrefix+etcdMember.ID) == "" {
+					t.Errorf("%s: addition of %s not recorded", test.name, etcdMember.ID)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteEnvironmentKeepsLockUntilStarted(t *testing.T) {
+	server, etcd := newFakeEtcd(t,
+		etcdMember("m1", "n1", "127.0.0.1"),
+		etcdMember("m2", "n2", "127.0.0.2"),
+	)
+	defer server.Close()
+	defer stubEtcdMemberHealth("m1", "m2", "added1")()
+
+	provider := newStaticProvider(t, "n3", testAddresses)
+	options := testSyncOptions(t, server)
+	options.lockKey = "/lock"
+	options.lockTTL = time.Minute
+	options.lockTimeout = time.Second
+
+	var buffer bytes.Buffer
+	if err := writeEnvironment(&buffer, provider, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buffer.String(), "ETCD_INITIAL_CLUSTER_STATE=existing\n") {
+		t.Errorf("unexpected environment: %s", buffer.String())
+	}
+	if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, []string{"m1", "m2", "added1"}) {
+		t.Fatalf("unexpected members: %v", actual)
+	}
+
+	// the lock is kept while the member doesn't start, other instances and
+	// other processes of this one wait for it
+	if holder := etcd.key("/lock"); holder != lockHolder("n3") {
+		t.Errorf("lock held by %q, expected %q", holder, lockHolder("n3"))
+	}
+	for _, holder := range []string{"n4/1", "n3/1"} {
+		lock, err := util.NewEtcdLock(server.URL, "/lock", holder, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lock.Lock(time.Second); err == nil {
+			t.Errorf("%s acquired the lock while the member didn't start", holder)
+		}
+	}
+
+	etcd.Lock()
+	etcd.members[2].Name = "n3"
+	etcd.Unlock()
+
+	if err := waitEtcdMember(provider, time.Second, 10*time.Millisecond, true, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if holder := etcd.key("/lock"); holder != "" {
+		t.Errorf("lock still held by %q once the member started", holder)
+	}
+}
+
+func TestReconcileAfterJoinKeepsLock(t *testing.T) {
+	server, etcd := newFakeEtcd(t,
+		etcdMember("m1", "n1", "127.0.0.1"),
+		etcdMember("m2", "n2", "127.0.0.2"),
+	)
+	defer server.Close()
+	defer stubEtcdMemberHealth("m1", "m2", "added1")()
+
+	provider := newStaticProvider(t, "n3", testAddresses)
+	options := testSyncOptions(t, server)
+	options.lockKey = "/lock"
+	options.lockTTL = time.Minute
+	options.lockTimeout = 100 * time.Millisecond
+
+	var buffer bytes.Buffer
+	if err := writeEnvironment(&buffer, provider, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// --watch reconciles right after joining, it waits for the member
+	if err := reconcileEtcdPeers(provider, options); err == nil {
+		t.Errorf("expected an error while the lock is kept")
+	}
+	if holder := etcd.key("/lock"); holder != lockHolder("n3") {
+		t.Errorf("lock held by %q, expected %q", holder, lockHolder("n3"))
+	}
+
+	etcd.Lock()
+	etcd.members[2].Name = "n3"
+	etcd.Unlock()
+	releaseInstanceJoinLock(server.URL, "n3", options)
+
+	if err := reconcileEtcdPeers(provider, options); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if holder := etcd.key("/lock"); holder != "" {
+		t.Errorf("lock still held by %q", holder)
+	}
+	if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, []string{"m1", "m2", "added1"}) {
+		t.Errorf("unexpected members: %v", actual)
+	}
+}
+
+func TestReconcileEtcdPeers(t *testing.T) {
+	server, etcd := newFakeEtcd(t)
+	defer server.Close()
+
+	// started members report themselves healthy through the fake
+	etcd.members = []client.Member{
+		etcdMember("m1", "n1", "127.0.0.1"),
+		etcdMember("m3", "n3", "127.0.0.3"),
+		etcdMember("x", "n9", "127.0.0.9"),
+	}
+	etcd.members[0].ClientURLs = []string{server.URL}
+	etcd.members[1].ClientURLs = []string{server.URL}
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	options := testSyncOptions(t, server)
+	options.lockKey = "/lock"
+	options.lockTTL = time.Minute
+	options.lockTimeout = time.Second
+
+	if err := reconcileEtcdPeers(newStaticProvider(t, "n3", testAddresses), options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the member of a gone instance is removed and the instance which didn't
+	// join yet is reported
+	if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, []string{"m1", "m3"}) {
+		t.Errorf("unexpected members: %v", actual)
+	}
+	if !strings.Contains(output.String(), "instance n2 (127.0.0.2) is not an etcd member") {
+		t.Errorf("missing instance not reported: %s", output.String())
+	}
+	if strings.Contains(output.String(), "instance n1") || strings.Contains(output.String(), "instance n3") {
+		t.Errorf("etcd members reported as missing: %s", output.String())
+	}
+
+	if holder := etcd.key("/lock"); holder != "" {
+		t.Errorf("lock still held by %q", holder)
+	}
+}
+
+func TestWriteEnvironmentDryRun(t *testing.T) {
+	server, etcd := newFakeEtcd(t,
+		etcdMember("m1", "n1", "127.0.0.1"),
+		etcdMember("m2", "n2", "127.0.0.2"),
+		etcdMember("old", "n0", "127.0.0.9"),
+	)
+	defer server.Close()
+	defer stubEtcdMemberHealth("m1", "m2")()
+
+	provider := newStaticProvider(t, "n3", testAddresses)
+	options := testSyncOptions(t, server)
+	options.dryRun = true
+	options.lockKey = "/lock"
+	options.lockTTL = time.Minute
+	options.lockTimeout = time.Second
+
+	var buffer bytes.Buffer
+	if err := writeEnvironment(&buffer, provider, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	plan := "# members to remove:\n" +
+		"#   old n0 http://127.0.0.9:2380\n" +
+		"# member to add:\n" +
+		"#   n3 http://127.0.0.3:2380\n"
+	if !strings.HasPrefix(buffer.String(), plan) || !strings.Contains(buffer.String(), "ETCD_INITIAL_CLUSTER_STATE=existing\n") {
+		t.Errorf("unexpected plan: %s", buffer.String())
+	}
+
+	if err := reconcileEtcdPeers(provider, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// nothing is changed, not even the lock
+	if writes := etcd.writeRequests(); len(writes) != 0 {
+		t.Errorf("unexpected writes: %v", writes)
+	}
+	if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, []string{"m1", "m2", "old"}) {
+		t.Errorf("unexpected members: %v", actual)
+	}
+}
@@ -0,0 +1,225 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/coreos/etcd/client"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+func NewWaitEtcdMemberCommand() cli.Command {
+	return cli.Command{
+		Name:  "wait-etcd-member",
+		Usage: `waits for the "etcd" member of this instance to start, removing it if it doesn't`,
+		Flags: append([]cli.Flag {
+			cli.DurationFlag{
+				Name: "timeout, t",
+				Value: 5 * time.Minute,
+				Usage: "maximum time to wait for the member to start",
+			},
+			cli.DurationFlag{
+				Name: "interval, i",
+				Value: 5 * time.Second,
+				Usage: "time between checks",
+			},
+			cli.BoolFlag{
+				Name: "no-rollback",
+				Usage: "keep the member if it doesn't start in time",
+			},
+		}, concatFlags(lockFlags(), memberFilterFlags(), addressingFlags(), endpointsFlags())...),
+		Action: handleWaitEtcdMember,
+	}
+}
+
+func handleWaitEtcdMember(c *cli.Context) {
+	addressing, err := newMemberAddressing(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	endpoints, err := newEtcdEndpoints(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	options := syncOptions{
+		lockKey:     c.String("lock-key"),
+		lockTTL:     c.Duration("lock-ttl"),
+		lockTimeout: c.Duration("lock-timeout"),
+		filter:      newMemberFilter(c),
+		addressing:  addressing,
+		endpoints:   endpoints,
+	}
+
+	provider, err := newProvider(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := waitEtcdMember(provider, c.Duration("timeout"), c.Duration("interval"), !c.Bool("no-rollback"), options); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// waitEtcdMember polls the cluster until the member of this instance has a
+// name, which is set once it starts, and a healthy client url. If it doesn't
+// start before the timeout the member is removed when rolling back, an
+// unstarted member reduces the fault tolerance of the cluster. A started but
+// unhealthy member is only reported. Either way the lock kept by
+// sync-etcd-peers after adding the member is released.
+func waitEtcdMember(provider providers.Provider, timeout, interval time.Duration, rollback bool, options syncOptions) error {
+	instanceId, err := provider.GetInstanceId()
+	if err != nil {
+		return err
+	}
+
+	clusterMembers, err := getClusterMembers(provider, "", options.filter, instanceId)
+	if err != nil {
+		return err
+	}
+	clusterMembersByName := clusterMembers.byName
+
+	selfHost, err := getSelfHost(provider, instanceId, clusterMembers.all, options.addressing)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("waiting for etcd member %s to start...\n", options.endpoints.peerURL(selfHost))
+
+	var etcdMember *client.Member
+	var goodEtcdClientURL string
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var etcdMembers []client.Member
+		etcdMembers, goodEtcdClientURL = listEtcdMembers(clusterMembersByName, "", options)
+		if etcdMembers == nil {
+			log.Printf("unable to reach any etcd member\n")
+		} else if etcdMember, err = findEtcdMemberByHost(etcdMembers, selfHost); err != nil {
+			// sync-etcd-peers may not have added it yet
+			log.Printf("%v\n", err)
+		} else if etcdMember.Name != "" && isEtcdMemberHealthy(*etcdMember) {
+			log.Printf("etcd member %s (%s) started\n", etcdMember.ID, etcdMember.Name)
+			releaseInstanceJoinLock(goodEtcdClientURL, instanceId, options)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(interval)
+	}
+
+	if goodEtcdClientURL == "" {
+		return errors.New("unable to reach any etcd member, giving up")
+	}
+
+	if etcdMember == nil || etcdMember.Name != "" || !rollback {
+		// nothing is removed, other instances may join
+		releaseInstanceJoinLock(goodEtcdClientURL, instanceId, options)
+
+		switch {
+		case etcdMember == nil:
+			return fmt.Errorf("no etcd member found for %s in %s", selfHost, timeout)
+		case etcdMember.Name != "":
+			return fmt.Errorf("etcd member %s (%s) started but isn't healthy after %s", etcdMember.ID, etcdMember.Name, timeout)
+		default:
+			return fmt.Errorf("etcd member %s didn't start in %s", etcdMember.ID, timeout)
+		}
+	}
+
+	// the lock kept by sync-etcd-peers is released once the member is gone,
+	// so no other instance joins counting on it
+	removed := false
+	err = withInstanceJoinLock(goodEtcdClientURL, instanceId, options, func() error {
+		// it may have started in the meantime
+		etcdMembers, err := util.EtcdListMembers(goodEtcdClientURL)
+		if err != nil {
+			return err
+		}
+
+		current := findEtcdMemberByID(etcdMembers, etcdMember.ID)
+		if current == nil {
+			log.Printf("etcd member %s is already gone\n", etcdMember.ID)
+			return nil
+		}
+		if current.Name != "" {
+			return fmt.Errorf("etcd member %s (%s) started after %s, keeping it", current.ID, current.Name, timeout)
+		}
+
+		removals, err := safeRemovals(etcdMembers, []client.Member{*current}, map[string]bool{current.ID: true}, 1)
+		if err != nil {
+			return err
+		}
+
+		removed = true
+		return removeEtcdMembers(goodEtcdClientURL, removals)
+	})
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("etcd member %s didn't start in %s", etcdMember.ID, timeout)
+	}
+
+	return fmt.Errorf("etcd member %s didn't start in %s, removed", etcdMember.ID, timeout)
+}
+
+// withInstanceJoinLock runs fn holding the lock and releases it afterwards.
+// The lock kept on behalf of the given instance by sync-etcd-peers is used as
+// is, otherwise it is acquired.
+func withInstanceJoinLock(etcdClientURL, instanceId string, options syncOptions, fn func() error) error {
+	if options.lockKey == "" || options.dryRun {
+		return fn()
+	}
+
+	lock, err := util.NewEtcdLock(etcdClientURL, options.lockKey, "", options.lockTTL)
+	if err != nil {
+		return err
+	}
+
+	holder, err := lock.Holder()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(holder, instanceId+"/") {
+		return withJoinLock(etcdClientURL, lockHolder(instanceId), options, fn)
+	}
+
+	err = fn()
+	releaseInstanceJoinLock(etcdClientURL, instanceId, options)
+
+	return err
+}
+
+func findEtcdMemberByID(etcdMembers []client.Member, id string) *client.Member {
+	for i, etcdMember := range etcdMembers {
+		if etcdMember.ID == id {
+			return &etcdMembers[i]
+		}
+	}
+	return nil
+}
+
+// findEtcdMemberByHost returns the etcd member whose peer url points to the
+// given host.
+func findEtcdMemberByHost(etcdMembers []client.Member, host string) (*client.Member, error) {
+	for i, etcdMember := range etcdMembers {
+		peerHost, err := etcdMemberPeerHost(etcdMember)
+		if err != nil {
+			return nil, err
+		}
+		if sameHost(peerHost, host) {
+			return &etcdMembers[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no etcd member found for %s", host)
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+)
+
+func TestWaitEtcdMember(t *testing.T) {
+	tests := []struct {
+		name     string
+		self     []client.Member
+		rollback bool
+		members  []string
+		fails    bool
+	}{
+		{
+			name:    "started",
+			self:    []client.Member{etcdMember("m3", "n3", "127.0.0.3")},
+			members: []string{"m1", "m2", "m3"},
+		},
+		{
+			name:     "unstarted is rolled back",
+			self:     []client.Member{etcdMember("m3", "", "127.0.0.3")},
+			rollback: true,
+			members:  []string{"m1", "m2"},
+			fails:    true,
+		},
+		{
+			name:    "unstarted is kept without rollback",
+			self:    []client.Member{etcdMember("m3", "", "127.0.0.3")},
+			members: []string{"m1", "m2", "m3"},
+			fails:   true,
+		},
+		{
+			name:     "started but unhealthy is kept",
+			self:     []client.Member{etcdMember("m4", "n3", "127.0.0.3")},
+			rollback: true,
+			members:  []string{"m1", "m2", "m4"},
+			fails:    true,
+		},
+		{
+			name:     "not found",
+			rollback: true,
+			members:  []string{"m1", "m2"},
+			fails:    true,
+		},
+	}
+
+	defer stubEtcdMemberHealth("m1", "m2", "m3")()
+
+	for _, test := range tests {
+		etcdMembers := append([]client.Member{etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2")}, test.self...)
+		server, etcd := newFakeEtcd(t, etcdMembers...)
+
+		err := waitEtcdMember(newStaticProvider(t, "n3", testAddresses), 50*time.Millisecond, 10*time.Millisecond, test.rollback, testSyncOptions(t, server))
+		server.Close()
+
+		if test.fails && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !test.fails && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+
+		if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, test.members) {
+			t.Errorf("%s: members %v, expected %v", test.name, actual, test.members)
+		}
+	}
+}
+
+func TestWaitEtcdMemberNotAddedYet(t *testing.T) {
+	server, etcd := newFakeEtcd(t, etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"))
+	defer server.Close()
+	defer stubEtcdMemberHealth("m1", "m2", "m3")()
+
+	// sync-etcd-peers adds the member a bit later
+	time.AfterFunc(50*time.Millisecond, func() {
+		etcd.Lock()
+		etcd.members = append(etcd.members, etcdMember("m3", "n3", "127.0.0.3"))
+		etcd.Unlock()
+	})
+
+	if err := waitEtcdMember(newStaticProvider(t, "n3", testAddresses), 5*time.Second, 10*time.Millisecond, true, testSyncOptions(t, server)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitEtcdMemberRollbackUnderLock(t *testing.T) {
+	defer stubEtcdMemberHealth("m1", "m2")()
+
+	tests := []struct {
+		name    string
+		holder  string
+		starts  bool
+		members []string
+	}{
+		{
+			name:    "lock kept by sync-etcd-peers",
+			holder:  "n3/1",
+			members: []string{"m1", "m2"},
+		},
+		{
+			name:    "member starting while waiting for the lock",
+			holder:  "n4/1",
+			starts:  true,
+			members: []string{"m1", "m2", "m3"},
+		},
+	}
+
+	for _, test := range tests {
+		server, etcd := newFakeEtcd(t, etcdMember("m1", "n1", "127.0.0.1"), etcdMember("m2", "n2", "127.0.0.2"), etcdMember("m3", "", "127.0.0.3"))
+		etcd.keys["/lock"] = test.holder
+
+		if test.starts {
+			// it starts once the timeout expired, then the lock is released
+			time.AfterFunc(200*time.Millisecond, func() {
+				etcd.Lock()
+				etcd.members[2].Name = "n3"
+				delete(etcd.keys, "/lock")
+				etcd.Unlock()
+			})
+		}
+
+		options := testSyncOptions(t, server)
+		options.lockKey = "/lock"
+		options.lockTTL = time.Minute
+		options.lockTimeout = 5 * time.Second
+
+		err := waitEtcdMember(newStaticProvider(t, "n3", testAddresses), 50*time.Millisecond, 10*time.Millisecond, true, options)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+
+		if actual := etcd.memberIDs(); !reflect.DeepEqual(actual, test.members) {
+			t.Errorf("%s: members %v, expected %v", test.name, actual, test.members)
+		}
+		if holder := etcd.key("/lock"); holder != "" {
+			t.Errorf("%s: lock still held by %q", test.name, holder)
+		}
+
+		server.Close()
+	}
+}
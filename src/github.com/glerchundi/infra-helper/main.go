@@ -4,18 +4,38 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/codegangsta/cli"
 	"github.com/glerchundi/infra-helper/command"
+	"github.com/glerchundi/infra-helper/providers"
+	_ "github.com/glerchundi/infra-helper/providers/aws"
+	_ "github.com/glerchundi/infra-helper/providers/azure"
+	_ "github.com/glerchundi/infra-helper/providers/dns"
+	_ "github.com/glerchundi/infra-helper/providers/gce"
+	_ "github.com/glerchundi/infra-helper/providers/openstack"
+	_ "github.com/glerchundi/infra-helper/providers/static"
 )
 
 func main() {
 	app := cli.NewApp()
 	app.Name = "infra-helper"
 	app.Version = "0.1.1"
-	app.Usage = "manage etcd cluster based on AWS autoscaling groups"
+	app.Usage = "manage etcd cluster membership based on the instances of a cloud or static provider"
+	app.Flags = append([]cli.Flag{
+		cli.StringFlag{
+			Name: "provider, p",
+			Value: "aws",
+			Usage: "cluster members provider (" + strings.Join(providers.Names(), ", ") + ")",
+			EnvVar: "INFRA_HELPER_PROVIDER",
+		},
+	}, providers.Flags()...)
 	app.Commands = []cli.Command{
 		command.NewSyncEtcdPeersCommand(),
 		command.NewListAutoscaleMembersCommand(),
+		command.NewWaitEtcdMemberCommand(),
+		command.NewLeaveEtcdClusterCommand(),
+		command.NewHandleLifecycleHooksCommand(),
 	}
 	app.RunAndExitOnError()
 }
\ No newline at end of file
@@ -5,36 +5,253 @@ package aws
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
 	"github.com/glerchundi/infra-helper/util"
 )
 
-type AwsMember struct {
-	name string
-	ipAddress string
-}
+func init() {
+	providers.Register(
+		"aws",
+		[]cli.Flag{
+			cli.StringFlag{
+				Name: "aws-region",
+				Usage: "AWS region (defaults to the one of the instance)",
+				EnvVar: "INFRA_HELPER_AWS_REGION,AWS_REGION",
+			},
+			cli.StringFlag{
+				Name: "aws-discovery",
+				Value: DiscoveryAutoscaling,
+				Usage: "how cluster members are discovered (autoscaling, tags)",
+				EnvVar: "INFRA_HELPER_AWS_DISCOVERY",
+			},
+			cli.StringFlag{
+				Name: "aws-autoscaling-groups",
+				Usage: "comma separated auto scaling groups composing the cluster (defaults to the one of the instance)",
+				EnvVar: "INFRA_HELPER_AWS_AUTOSCALING_GROUPS",
+			},
+			cli.StringFlag{
+				Name: "aws-autoscaling-group-pattern",
+				Usage: "regular expression matching the auto scaling groups composing the cluster, i.e. ^etcd-",
+				EnvVar: "INFRA_HELPER_AWS_AUTOSCALING_GROUP_PATTERN",
+			},
+			cli.StringFlag{
+				Name: "aws-cluster-tag",
+				Value: DefaultClusterTag,
+				Usage: "EC2 tag whose value names the cluster when discovering by tags",
+				EnvVar: "INFRA_HELPER_AWS_CLUSTER_TAG",
+			},
+			cli.StringFlag{
+				Name: "aws-instance-states",
+				Value: "pending,running,shutting-down,stopping",
+				Usage: "comma separated EC2 instance states to consider when discovering by tags",
+				EnvVar: "INFRA_HELPER_AWS_INSTANCE_STATES",
+			},
+			cli.StringFlag{
+				Name: "aws-profile",
+				Usage: "AWS shared credentials profile",
+				EnvVar: "INFRA_HELPER_AWS_PROFILE,AWS_PROFILE",
+			},
+			cli.StringFlag{
+				Name: "aws-role-arn",
+				Usage: "AWS role to assume through STS",
+				EnvVar: "INFRA_HELPER_AWS_ROLE_ARN",
+			},
+			cli.StringFlag{
+				Name: "aws-role-session-name",
+				Value: "infra-helper",
+				Usage: "AWS session name of the assumed role",
+				EnvVar: "INFRA_HELPER_AWS_ROLE_SESSION_NAME",
+			},
+			cli.StringFlag{
+				Name: "aws-external-id",
+				Usage: "AWS external id required to assume the role",
+				EnvVar: "INFRA_HELPER_AWS_EXTERNAL_ID",
+			},
+			cli.StringFlag{
+				Name: "aws-metadata-endpoint",
+				Value: DefaultMetadataEndpoint,
+				Usage: "AWS instance metadata service endpoint",
+				EnvVar: "INFRA_HELPER_AWS_METADATA_ENDPOINT",
+			},
+			cli.StringFlag{
+				Name: "aws-autoscaling-endpoint",
+				Usage: "AWS auto scaling api endpoint (defaults to the regional one)",
+				EnvVar: "INFRA_HELPER_AWS_AUTOSCALING_ENDPOINT",
+			},
+			cli.StringFlag{
+				Name: "aws-ec2-endpoint",
+				Usage: "AWS EC2 api endpoint (defaults to the regional one)",
+				EnvVar: "INFRA_HELPER_AWS_EC2_ENDPOINT",
+			},
+			cli.StringFlag{
+				Name: "aws-sts-endpoint",
+				Usage: "AWS STS api endpoint (defaults to the global one)",
+				EnvVar: "INFRA_HELPER_AWS_STS_ENDPOINT",
+			},
+			cli.StringFlag{
+				Name: "aws-sqs-endpoint",
+				Usage: "AWS SQS api endpoint (defaults to the regional one)",
+				EnvVar: "INFRA_HELPER_AWS_SQS_ENDPOINT",
+			},
+			cli.StringFlag{
+				Name: "aws-lifecycle-queue-url",
+				Usage: "SQS queue receiving the auto scaling lifecycle hook notifications",
+				EnvVar: "INFRA_HELPER_AWS_LIFECYCLE_QUEUE_URL",
+			},
+			cli.StringFlag{
+				Name: "aws-lifecycle-hook-name",
+				Usage: "lifecycle hook whose notifications are handled (empty means any hook of the cluster groups)",
+				EnvVar: "INFRA_HELPER_AWS_LIFECYCLE_HOOK_NAME",
+			},
+			cli.BoolFlag{
+				Name: "aws-allow-imdsv1",
+				Usage: "fall back to IMDSv1 if an IMDSv2 session token cannot be obtained",
+				EnvVar: "INFRA_HELPER_AWS_ALLOW_IMDSV1",
+			},
+		},
+		func(c *cli.Context) (providers.Provider, error) {
+			discovery := c.GlobalString("aws-discovery")
+			if discovery != DiscoveryAutoscaling && discovery != DiscoveryTags {
+				return nil, fmt.Errorf("unknown aws discovery: %s", discovery)
+			}
 
-func (awsMember *AwsMember) GetName() string {
-	return awsMember.name
+			autoscalingGroupPattern := c.GlobalString("aws-autoscaling-group-pattern")
+			if _, err := regexp.Compile(autoscalingGroupPattern); err != nil {
+				return nil, fmt.Errorf("invalid auto scaling group pattern: %v", err)
+			}
+
+			return New(Config{
+				Discovery:               discovery,
+				AutoscalingGroups:       util.SplitList(c.GlobalString("aws-autoscaling-groups")),
+				AutoscalingGroupPattern: autoscalingGroupPattern,
+				ClusterTag:              c.GlobalString("aws-cluster-tag"),
+				InstanceStates:          util.SplitList(c.GlobalString("aws-instance-states")),
+				Region:                  c.GlobalString("aws-region"),
+				Profile:                 c.GlobalString("aws-profile"),
+				RoleARN:                 c.GlobalString("aws-role-arn"),
+				RoleSessionName:         c.GlobalString("aws-role-session-name"),
+				ExternalID:              c.GlobalString("aws-external-id"),
+				MetadataEndpoint:        c.GlobalString("aws-metadata-endpoint"),
+				AutoscalingEndpoint:     c.GlobalString("aws-autoscaling-endpoint"),
+				EC2Endpoint:             c.GlobalString("aws-ec2-endpoint"),
+				STSEndpoint:             c.GlobalString("aws-sts-endpoint"),
+				SQSEndpoint:             c.GlobalString("aws-sqs-endpoint"),
+				LifecycleQueueURL:       c.GlobalString("aws-lifecycle-queue-url"),
+				LifecycleHookName:       c.GlobalString("aws-lifecycle-hook-name"),
+				AllowIMDSv1:             c.GlobalBool("aws-allow-imdsv1"),
+			}), nil
+		},
+	)
 }
 
-func (awsMember *AwsMember) GetIPAddress() string {
-	return awsMember.ipAddress
+const (
+	DiscoveryAutoscaling = "autoscaling"
+	DiscoveryTags        = "tags"
+
+	DefaultClusterTag = "etcd-cluster"
+)
+
+type Config struct {
+	// Discovery tells whether cluster members are the instances of an auto
+	// scaling group or the ones tagged with the same ClusterTag value and in
+	// one of the InstanceStates.
+	Discovery      string
+	ClusterTag     string
+	InstanceStates []string
+
+	// AutoscalingGroups and AutoscalingGroupPattern define the cluster as the
+	// union of several auto scaling groups instead of the one this instance
+	// belongs to, i.e. one group per availability zone.
+	AutoscalingGroups       []string
+	AutoscalingGroupPattern string
+
+	// Region to use, if empty it is derived from the instance availability
+	// zone.
+	Region string
+
+	// Profile of the shared credentials file to use instead of the default
+	// credentials chain.
+	Profile string
+
+	// RoleARN, if set, is assumed through STS using the credentials above,
+	// optionally presenting an ExternalID.
+	RoleARN         string
+	RoleSessionName string
+	ExternalID      string
+
+	// Endpoints, empty ones default to the metadata service and the regional
+	// AWS endpoints. Useful to work against local stand-ins or VPC endpoints.
+	MetadataEndpoint    string
+	AutoscalingEndpoint string
+	EC2Endpoint         string
+	STSEndpoint         string
+	SQSEndpoint         string
+
+	// LifecycleQueueURL is the SQS queue auto scaling lifecycle hooks notify
+	// terminations to, only notifications of the cluster groups (and of the
+	// LifecycleHookName hook, if set) are handled.
+	LifecycleQueueURL string
+	LifecycleHookName string
+
+	// AllowIMDSv1 allows unauthenticated metadata requests when an IMDSv2
+	// session token cannot be obtained.
+	AllowIMDSv1 bool
 }
 
-func New() *Aws {
-	return &Aws{}
+func New(config Config) *Aws {
+	metadataEndpoint := config.MetadataEndpoint
+	if metadataEndpoint == "" {
+		metadataEndpoint = DefaultMetadataEndpoint
+	}
+
+	// nil means the default credentials chain
+	var creds *credentials.Credentials
+	if config.Profile != "" {
+		creds = credentials.NewSharedCredentials("", config.Profile)
+	}
+	if config.RoleARN != "" {
+		roleSessionName := config.RoleSessionName
+		if roleSessionName == "" {
+			roleSessionName = "infra-helper"
+		}
+		creds = newAssumeRoleCredentials(
+			&aws.Config{Credentials: creds, Endpoint: config.STSEndpoint, Region: config.Region},
+			config.RoleARN, roleSessionName, config.ExternalID,
+		)
+	}
+
+	return &Aws{
+		config:      config,
+		credentials: creds,
+		metadata:    newMetadataClient(strings.TrimRight(metadataEndpoint, "/"), config.AllowIMDSv1),
+	}
 }
 
 type Aws struct {
+	config      Config
+	credentials *credentials.Credentials
+	metadata    *metadataClient
+}
+
+// services holds the AWS api clients used by the provider.
+type services struct {
+	autoscaling *autoscaling.AutoScaling
+	ec2         *ec2.EC2
+	sqs         *aws.Service
 }
 
 func (aws *Aws) GetInstanceId() (string, error) {
 	// Instance Id
-	instanceId, err := util.HttpGet("http://169.254.169.254/latest/meta-data/instance-id")
+	instanceId, err := aws.metadata.get("instance-id")
 	if err != nil {
 		return "", err
 	}
@@ -44,7 +261,7 @@ func (aws *Aws) GetInstanceId() (string, error) {
 
 func (aws *Aws) GetInstancePrivateAddress() (string, error) {
 	// Local IPv4 (Private Address)
-	localIp, err := util.HttpGet("http://169.254.169.254/latest/meta-data/local-ipv4")
+	localIp, err := aws.metadata.get("local-ipv4")
 	if err != nil {
 		return "", err
 	}
@@ -52,107 +269,315 @@ func (aws *Aws) GetInstancePrivateAddress() (string, error) {
 	return localIp, nil
 }
 
-func (aws *Aws) GetClusterMembers() (map[string]string, error) {
-	return GetClusterMembersByFunc(func(region string)(*autoscaling.Group, error) {
-		// Instance Id
-		instanceId, err := aws.GetInstanceId()
-		if err != nil {
-			return nil, err
-		}
-		return findAutoscalingGroupInstanceIdBelongs(instanceId, region)
-	})
+func (aws *Aws) GetClusterMembers() (map[string]providers.Member, error) {
+	if aws.config.Discovery == DiscoveryTags {
+		return aws.getClusterMembersByTag("")
+	}
+
+	return aws.GetClusterMembersByFunc(aws.findClusterAutoscalingGroups)
 }
 
-func (aws *Aws) GetClusterMembersByName(name string) (map[string]string, error) {
-	return GetClusterMembersByFunc(func(region string)(*autoscaling.Group, error) {
-		return findAutoscalingGroupByName(name, region)
+// findClusterAutoscalingGroups returns the configured auto scaling groups or,
+// if none, the one this instance belongs to.
+func (aws *Aws) findClusterAutoscalingGroups(svcs *services) ([]*autoscaling.Group, error) {
+	if len(aws.config.AutoscalingGroups) > 0 || aws.config.AutoscalingGroupPattern != "" {
+		return findAutoscalingGroupsByConfig(aws.config, svcs)
+	}
+
+	// Instance Id
+	instanceId, err := aws.GetInstanceId()
+	if err != nil {
+		return nil, err
+	}
+	return findAutoscalingGroupInstanceIdBelongs(instanceId, svcs)
+}
+
+func (aws *Aws) GetClusterMembersByName(name string) (map[string]providers.Member, error) {
+	if aws.config.Discovery == DiscoveryTags {
+		return aws.getClusterMembersByTag(name)
+	}
+
+	// several comma separated group names are merged into one cluster
+	return aws.GetClusterMembersByFunc(func(svcs *services)([]*autoscaling.Group, error) {
+		return findAutoscalingGroupsByNames(util.SplitList(name), svcs)
 	})
 }
 
-func GetClusterMembersByFunc(findAutoscalingGroup func(string)(*autoscaling.Group, error)) (map[string]string, error) {
-	// Availability Zone
-	availabilityZone, err := util.HttpGet("http://169.254.169.254/latest/meta-data/placement/availability-zone")
+func (aws *Aws) GetClusterMembersByFunc(findAutoscalingGroups func(*services)([]*autoscaling.Group, error)) (map[string]providers.Member, error) {
+	svcs, err := aws.newServices()
 	if err != nil {
 		return nil, err
 	}
 
-	// Region
-	region := availabilityZone[:len(availabilityZone)-1]
-
-	// Find which is the autoscaling group
-	autoscalingGroup, err := findAutoscalingGroup(region)
+	// Find which are the autoscaling groups
+	autoscalingGroups, err := findAutoscalingGroups(svcs)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create list of instance identifiers
+	instances := make([]*autoscaling.Instance, 0)
 	instanceIds := make([]*string, 0)
-	for _, i := range autoscalingGroup.Instances {
-		instanceIds = append(instanceIds, i.InstanceID)
+	for _, asg := range autoscalingGroups {
+		for _, i := range asg.Instances {
+			instances = append(instances, i)
+			instanceIds = append(instanceIds, i.InstanceID)
+		}
 	}
 
 	// Find EC2 instance properties
-	privateAddresses, err := findEC2InstancesPrivateAddresses(instanceIds, region)
+	ec2Instances, err := findEC2Instances(instanceIds, svcs)
 	if err != nil {
 		return nil, err
 	}
 
-	return privateAddresses, nil
+	// Attach auto scaling states to the instances
+	members := make(map[string]providers.Member)
+	for _, i := range instances {
+		instance, ok := ec2Instances[*i.InstanceID]
+		if !ok || instance.PrivateIPAddress == nil {
+			continue
+		}
+		member := newMember(instance)
+		member.LifecycleState = stringValue(i.LifecycleState)
+		member.HealthStatus = stringValue(i.HealthStatus)
+		members[*i.InstanceID] = member
+	}
+
+	return members, nil
 }
 
-func findAutoscalingGroupInstanceIdBelongs(instanceId, region string) (*autoscaling.Group, error) {
-	return findAutoscalingGroupByFunc(region, func(asg *autoscaling.Group)bool {
-		for _, instance := range asg.Instances {
-			if *(instance.InstanceID) == instanceId {
-				return true
-			}
+// getClusterMembersByTag returns the instances whose cluster tag value is the
+// given one or, if empty, the same as the one of this instance.
+func (a *Aws) getClusterMembersByTag(value string) (map[string]providers.Member, error) {
+	svcs, err := a.newServices()
+	if err != nil {
+		return nil, err
+	}
+
+	if value == "" {
+		instanceId, err := a.GetInstanceId()
+		if err != nil {
+			return nil, err
 		}
-		return false
-	})
+
+		instances, err := describeEC2Instances(&ec2.DescribeInstancesInput{
+			InstanceIDs: []*string{aws.String(instanceId)},
+		}, svcs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, instance := range instances {
+			value = newMember(instance).Tags[a.config.ClusterTag]
+		}
+
+		if value == "" {
+			return nil, fmt.Errorf("instance %s has no %s tag", instanceId, a.config.ClusterTag)
+		}
+	}
+
+	filters := []*ec2.Filter{
+		{Name: aws.String("tag:" + a.config.ClusterTag), Values: []*string{aws.String(value)}},
+	}
+	if len(a.config.InstanceStates) > 0 {
+		states := make([]*string, 0)
+		for _, state := range a.config.InstanceStates {
+			states = append(states, aws.String(state))
+		}
+		filters = append(filters, &ec2.Filter{Name: aws.String("instance-state-name"), Values: states})
+	}
+
+	instances, err := describeEC2Instances(&ec2.DescribeInstancesInput{Filters: filters}, svcs)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]providers.Member)
+	for _, instance := range instances {
+		if instance.PrivateIPAddress == nil {
+			continue
+		}
+		members[*instance.InstanceID] = newMember(instance)
+	}
+
+	return members, nil
 }
 
-func findAutoscalingGroupByName(name, region string) (*autoscaling.Group, error) {
-	return findAutoscalingGroupByFunc(region, func(asg *autoscaling.Group)bool {
-		return *asg.AutoScalingGroupName == name
-	})
+func (aws *Aws) getRegion() (string, error) {
+	if aws.config.Region != "" {
+		return aws.config.Region, nil
+	}
+
+	// Availability Zone
+	availabilityZone, err := aws.metadata.get("placement/availability-zone")
+	if err != nil {
+		return "", err
+	}
+
+	// Region
+	return availabilityZone[:len(availabilityZone)-1], nil
 }
 
-func findAutoscalingGroupByFunc(region string, predicate func(*autoscaling.Group)bool) (*autoscaling.Group, error) {
-	var autoscalingGroup *autoscaling.Group
+func (a *Aws) newServices() (*services, error) {
+	region, err := a.getRegion()
+	if err != nil {
+		return nil, err
+	}
 
-	svc := autoscaling.New(&aws.Config{Region: region})
-	out, err := svc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{})
+	return &services{
+		autoscaling: autoscaling.New(&aws.Config{Region: region, Endpoint: a.config.AutoscalingEndpoint, Credentials: a.credentials}),
+		ec2:         ec2.New(&aws.Config{Region: region, Endpoint: a.config.EC2Endpoint, Credentials: a.credentials}),
+		sqs:         newSQSService(&aws.Config{Region: region, Endpoint: a.config.SQSEndpoint, Credentials: a.credentials}),
+	}, nil
+}
+
+func findAutoscalingGroupInstanceIdBelongs(instanceId string, svcs *services) ([]*autoscaling.Group, error) {
+	out, err := svcs.autoscaling.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIDs: []*string{aws.String(instanceId)},
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, asg := range out.AutoScalingGroups {
-		if predicate(asg) {
-			autoscalingGroup = asg
-			break
+	if len(out.AutoScalingInstances) == 0 {
+		return nil, errors.New("failed to get the auto scaling group name")
+	}
+
+	return findAutoscalingGroupsByNames([]string{*out.AutoScalingInstances[0].AutoScalingGroupName}, svcs)
+}
+
+// findAutoscalingGroupsByConfig returns the union of the configured auto
+// scaling groups and the ones matching the configured pattern.
+func findAutoscalingGroupsByConfig(config Config, svcs *services) ([]*autoscaling.Group, error) {
+	autoscalingGroups := make([]*autoscaling.Group, 0)
+	if len(config.AutoscalingGroups) > 0 {
+		asgs, err := findAutoscalingGroupsByNames(config.AutoscalingGroups, svcs)
+		if err != nil {
+			return nil, err
 		}
+		autoscalingGroups = append(autoscalingGroups, asgs...)
 	}
 
-	if autoscalingGroup == nil {
-		return nil, errors.New("failed to get the auto scaling group name")
+	if config.AutoscalingGroupPattern != "" {
+		pattern, err := regexp.Compile(config.AutoscalingGroupPattern)
+		if err != nil {
+			return nil, err
+		}
+
+		asgs, err := findAutoscalingGroupsByFunc(svcs, &autoscaling.DescribeAutoScalingGroupsInput{}, func(asg *autoscaling.Group)bool {
+			return pattern.MatchString(*asg.AutoScalingGroupName)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(asgs) == 0 {
+			return nil, fmt.Errorf("no auto scaling group matches %s", config.AutoscalingGroupPattern)
+		}
+
+		// don't count twice groups both listed and matched
+		for _, asg := range asgs {
+			if !containsAutoscalingGroup(autoscalingGroups, *asg.AutoScalingGroupName) {
+				autoscalingGroups = append(autoscalingGroups, asg)
+			}
+		}
 	}
 
-	return autoscalingGroup, nil
+	return autoscalingGroups, nil
 }
 
-func findEC2InstancesPrivateAddresses(instanceIds []*string, region string) (map[string]string, error) {
-	svc := ec2.New(&aws.Config{Region: region})
-	out, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIDs: instanceIds})
+func findAutoscalingGroupsByNames(names []string, svcs *services) ([]*autoscaling.Group, error) {
+	if len(names) == 0 {
+		return nil, errors.New("auto scaling group name not specified")
+	}
+
+	wanted := make(map[string]bool)
+	input := &autoscaling.DescribeAutoScalingGroupsInput{}
+	for _, name := range names {
+		wanted[name] = true
+		input.AutoScalingGroupNames = append(input.AutoScalingGroupNames, aws.String(name))
+	}
+
+	autoscalingGroups, err := findAutoscalingGroupsByFunc(svcs, input, func(asg *autoscaling.Group)bool {
+		return wanted[*asg.AutoScalingGroupName]
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	privateAddresses := make(map[string]string)
-	for _, reservation := range out.Reservations {
-		for _, instance := range reservation.Instances {
-			privateAddresses[*instance.InstanceID] = *instance.PrivateIPAddress
+	for _, name := range names {
+		if !containsAutoscalingGroup(autoscalingGroups, name) {
+			return nil, fmt.Errorf("failed to get the auto scaling group %s", name)
+		}
+	}
+
+	return autoscalingGroups, nil
+}
+
+// findAutoscalingGroupsByFunc returns every auto scaling group described by
+// the input which satisfies the predicate, following the pagination tokens.
+// The SDK pager is not used as it panics copying the input on newer Go
+// versions.
+func findAutoscalingGroupsByFunc(svcs *services, input *autoscaling.DescribeAutoScalingGroupsInput, predicate func(*autoscaling.Group)bool) ([]*autoscaling.Group, error) {
+	autoscalingGroups := make([]*autoscaling.Group, 0)
+
+	// don't modify the caller input when paginating
+	in := *input
+
+	for {
+		out, err := svcs.autoscaling.DescribeAutoScalingGroups(&in)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, asg := range out.AutoScalingGroups {
+			if predicate(asg) {
+				autoscalingGroups = append(autoscalingGroups, asg)
+			}
+		}
+
+		if stringValue(out.NextToken) == "" {
+			break
 		}
+		in.NextToken = out.NextToken
 	}
 
-	return privateAddresses, nil
+	return autoscalingGroups, nil
+}
+
+func containsAutoscalingGroup(autoscalingGroups []*autoscaling.Group, name string) bool {
+	for _, asg := range autoscalingGroups {
+		if *asg.AutoScalingGroupName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func findEC2Instances(instanceIds []*string, svcs *services) (map[string]*ec2Instance, error) {
+	instances := make(map[string]*ec2Instance)
+
+	// an empty instance id list would describe every instance
+	if len(instanceIds) == 0 {
+		return instances, nil
+	}
+
+	out, err := describeEC2Instances(&ec2.DescribeInstancesInput{InstanceIDs: instanceIds}, svcs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range out {
+		instances[*instance.InstanceID] = instance
+	}
+
+	return instances, nil
+}
+
+func stringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
 }
@@ -0,0 +1,285 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+const (
+	fakeAutoscalingGroups = `<AutoScalingGroups>
+		<member><AutoScalingGroupName>etcd-a</AutoScalingGroupName><Instances>
+			<member><InstanceId>i-1</InstanceId><LifecycleState>InService</LifecycleState><HealthStatus>Healthy</HealthStatus></member>
+			<member><InstanceId>i-2</InstanceId><LifecycleState>Pending</LifecycleState><HealthStatus>Healthy</HealthStatus></member>
+		</Instances></member>
+		<member><AutoScalingGroupName>web</AutoScalingGroupName><Instances>
+			<member><InstanceId>i-9</InstanceId><LifecycleState>InService</LifecycleState><HealthStatus>Healthy</HealthStatus></member>
+		</Instances></member>
+	</AutoScalingGroups>`
+
+	fakeAutoscalingGroupsNextPage = `<AutoScalingGroups>
+		<member><AutoScalingGroupName>etcd-b</AutoScalingGroupName><Instances>
+			<member><InstanceId>i-3</InstanceId><LifecycleState>Terminating:Wait</LifecycleState><HealthStatus>Unhealthy</HealthStatus></member>
+		</Instances></member>
+	</AutoScalingGroups>`
+)
+
+// fakeInstances are the EC2 instances as listed in DescribeInstances items.
+var fakeInstances = map[string]string{
+	"i-1": `<instanceId>i-1</instanceId><privateIpAddress>10.0.0.1</privateIpAddress><ipAddress>1.2.3.4</ipAddress>
+		<privateDnsName>ip-10-0-0-1.ec2.internal</privateDnsName><launchTime>2015-10-01T10:00:00.000Z</launchTime>
+		<placement><availabilityZone>eu-west-1a</availabilityZone></placement><instanceState><name>running</name></instanceState>
+		<tagSet><item><key>etcd-cluster</key><value>prod</value></item></tagSet>
+		<networkInterfaceSet><item><ipv6AddressesSet/></item><item><ipv6AddressesSet><item><ipv6Address>2001:db8::1</ipv6Address></item></ipv6AddressesSet></item></networkInterfaceSet>`,
+	"i-2": `<instanceId>i-2</instanceId><privateIpAddress>10.0.0.2</privateIpAddress><instanceState><name>pending</name></instanceState>
+		<tagSet><item><key>etcd-cluster</key><value>prod</value></item></tagSet>`,
+	"i-3": `<instanceId>i-3</instanceId><privateIpAddress>10.0.0.3</privateIpAddress><instanceState><name>shutting-down</name></instanceState>`,
+	"i-9": `<instanceId>i-9</instanceId><privateIpAddress>10.0.0.9</privateIpAddress><instanceState><name>running</name></instanceState>`,
+	"i-0": `<instanceId>i-0</instanceId><instanceState><name>pending</name></instanceState>`,
+}
+
+// newFakeAws serves the instance metadata service (IMDSv2 only unless told
+// otherwise) under /latest and the auto scaling, EC2 and SQS Query apis under /.
+// Every api paginates its responses.
+func newFakeAws(t *testing.T, imdsV1Only bool) (*httptest.Server, *Aws, *[]string) {
+	requests := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/latest/") {
+			serveFakeMetadata(w, r, imdsV1Only)
+			return
+		}
+
+		if r.Method != "POST" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		r.ParseForm()
+		action := r.PostForm.Get("Action")
+		requests = append(requests, r.PostForm.Encode())
+
+		var result string
+		switch action {
+		case "DescribeAutoScalingInstances":
+			result = `<AutoScalingInstances><member><InstanceId>i-1</InstanceId><AutoScalingGroupName>etcd-a</AutoScalingGroupName></member></AutoScalingInstances>`
+		case "DescribeAutoScalingGroups":
+			if r.PostForm.Get("NextToken") == "" {
+				result = fakeAutoscalingGroups + `<NextToken>page2</NextToken>`
+			} else {
+				result = fakeAutoscalingGroupsNextPage
+			}
+		case "DescribeInstances":
+			serveFakeDescribeInstances(w, r)
+			return
+		case "ReceiveMessage":
+			result = fakeLifecycleMessages()
+		case "DeleteMessage", "CompleteLifecycleAction":
+			result = ""
+		default:
+			t.Logf("unexpected action: %s", action)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="http://autoscaling.amazonaws.com/doc/2011-01-01/"><%[1]sResult>%s</%[1]sResult></%[1]sResponse>`, action, result)
+	}))
+
+	aws := New(Config{
+		Discovery:           DiscoveryAutoscaling,
+		ClusterTag:          DefaultClusterTag,
+		MetadataEndpoint:    server.URL,
+		AutoscalingEndpoint: server.URL,
+		EC2Endpoint:         server.URL,
+		SQSEndpoint:         server.URL,
+		LifecycleQueueURL:   server.URL + "/123456789012/lifecycle",
+		AllowIMDSv1:         imdsV1Only,
+	})
+	aws.credentials = credentials.NewStaticCredentials("id", "secret", "")
+
+	return server, aws, &requests
+}
+
+func serveFakeMetadata(w http.ResponseWriter, r *http.Request, imdsV1Only bool) {
+	if r.URL.Path == "/latest/api/token" {
+		if r.Method != "PUT" || imdsV1Only {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "token")
+		return
+	}
+
+	if !imdsV1Only && r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	metadata := map[string]string{
+		"/latest/meta-data/instance-id":                 "i-1",
+		"/latest/meta-data/local-ipv4":                  "10.0.0.1",
+		"/latest/meta-data/placement/availability-zone": "eu-west-1a",
+	}
+	out, ok := metadata[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	fmt.Fprint(w, out)
+}
+
+// serveFakeDescribeInstances lists the requested instances, or the ones
+// tagged as the filter says, one per page.
+func serveFakeDescribeInstances(w http.ResponseWriter, r *http.Request) {
+	ids := make([]string, 0)
+	for i := 1; r.PostForm.Get(fmt.Sprintf("InstanceId.%d", i)) != ""; i++ {
+		ids = append(ids, r.PostForm.Get(fmt.Sprintf("InstanceId.%d", i)))
+	}
+	if r.PostForm.Get("Filter.1.Name") == "tag:etcd-cluster" && r.PostForm.Get("Filter.1.Value.1") == "prod" {
+		ids = append(ids, "i-0", "i-1", "i-2")
+	}
+
+	// the next token is the index of the instance to list
+	index := 0
+	fmt.Sscanf(r.PostForm.Get("NextToken"), "%d", &index)
+
+	items := ""
+	nextToken := ""
+	if index < len(ids) {
+		items = "<item>" + fakeInstances[ids[index]] + "</item>"
+		if index+1 < len(ids) {
+			nextToken = fmt.Sprintf("<nextToken>%d</nextToken>", index+1)
+		}
+	}
+
+	fmt.Fprintf(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2015-04-15/">%s
+		<reservationSet><item><instancesSet>%s</instancesSet></item></reservationSet></DescribeInstancesResponse>`, nextToken, items)
+}
+
+func TestGetInstance(t *testing.T) {
+	for _, imdsV1Only := range []bool{false, true} {
+		server, aws, _ := newFakeAws(t, imdsV1Only)
+
+		if id, err := aws.GetInstanceId(); err != nil || id != "i-1" {
+			t.Errorf("imdsv1 %t: GetInstanceId() = %q, %v", imdsV1Only, id, err)
+		}
+		if ip, err := aws.GetInstancePrivateAddress(); err != nil || ip != "10.0.0.1" {
+			t.Errorf("imdsv1 %t: GetInstancePrivateAddress() = %q, %v", imdsV1Only, ip, err)
+		}
+
+		server.Close()
+	}
+
+	// IMDSv1 is not used unless allowed
+	server, aws, _ := newFakeAws(t, true)
+	defer server.Close()
+
+	aws.metadata.allowV1 = false
+	if _, err := aws.GetInstanceId(); err == nil {
+		t.Errorf("expected an error without an IMDSv2 token")
+	}
+}
+
+func TestGetClusterMembers(t *testing.T) {
+	server, aws, _ := newFakeAws(t, false)
+	defer server.Close()
+
+	members, err := aws.GetClusterMembers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(members) != 2 {
+		t.Fatalf("expected the 2 members of etcd-a, got %v", members)
+	}
+
+	first := members["i-1"]
+	if first.PrivateAddress != "10.0.0.1" || first.PublicAddress != "1.2.3.4" || first.IPv6Address != "2001:db8::1" ||
+		first.PrivateDNSName != "ip-10-0-0-1.ec2.internal" || first.Zone != "eu-west-1a" || first.Tags["etcd-cluster"] != "prod" ||
+		first.LifecycleState != "InService" || first.HealthStatus != "Healthy" ||
+		!first.LaunchTime.Equal(time.Date(2015, 10, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected member: %+v", first)
+	}
+
+	// auto scaling states take precedence over the EC2 ones
+	if second := members["i-2"]; second.PrivateAddress != "10.0.0.2" || second.LifecycleState != "Pending" {
+		t.Errorf("unexpected member: %+v", second)
+	}
+}
+
+func TestGetClusterMembersByConfig(t *testing.T) {
+	server, aws, _ := newFakeAws(t, false)
+	defer server.Close()
+
+	// groups listed and matched by the pattern are merged
+	aws.config.AutoscalingGroups = []string{"etcd-a"}
+	aws.config.AutoscalingGroupPattern = "^etcd-"
+
+	members, err := aws.GetClusterMembers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(members) != 3 || members["i-3"].LifecycleState != "Terminating:Wait" || members["i-3"].HealthStatus != "Unhealthy" {
+		t.Errorf("unexpected members: %v", members)
+	}
+
+	aws.config.AutoscalingGroupPattern = "^db-"
+	if _, err := aws.GetClusterMembers(); err == nil {
+		t.Errorf("expected an error for a pattern matching no group")
+	}
+}
+
+func TestGetClusterMembersByName(t *testing.T) {
+	server, aws, _ := newFakeAws(t, false)
+	defer server.Close()
+
+	members, err := aws.GetClusterMembersByName("etcd-a, etcd-b")
+	if err != nil || len(members) != 3 {
+		t.Errorf("unexpected members %v, %v", members, err)
+	}
+
+	if _, err := aws.GetClusterMembersByName("etcd-a,etcd-c"); err == nil {
+		t.Errorf("expected an error for a missing group")
+	}
+}
+
+func TestGetClusterMembersByTag(t *testing.T) {
+	server, aws, requests := newFakeAws(t, false)
+	defer server.Close()
+
+	aws.config.Discovery = DiscoveryTags
+	aws.config.InstanceStates = []string{"pending", "running"}
+
+	members, err := aws.GetClusterMembers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// i-0 has no address yet
+	if len(members) != 2 || members["i-1"].LifecycleState != "InService" || members["i-2"].LifecycleState != "Pending" {
+		t.Errorf("unexpected members: %v", members)
+	}
+
+	filtered := false
+	for _, request := range *requests {
+		if strings.Contains(request, "Filter.2.Name=instance-state-name") &&
+			strings.Contains(request, "Filter.2.Value.1=pending") && strings.Contains(request, "Filter.2.Value.2=running") {
+			filtered = true
+		}
+	}
+	if !filtered {
+		t.Errorf("instance states not filtered: %v", *requests)
+	}
+
+	if members, err := aws.GetClusterMembersByName("dev"); err != nil || len(members) != 0 {
+		t.Errorf("unexpected members of dev %v, %v", members, err)
+	}
+}
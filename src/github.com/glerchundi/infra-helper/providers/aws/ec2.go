@@ -0,0 +1,142 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+// ec2Instance holds the subset of the DescribeInstances response used to
+// build cluster members. The vendored SDK predates EC2 IPv6 support so the
+// response is decoded into these types instead of ec2.Instance.
+type ec2Instance struct {
+	InstanceID        *string                `locationName:"instanceId" type:"string"`
+	PrivateIPAddress  *string                `locationName:"privateIpAddress" type:"string"`
+	PublicIPAddress   *string                `locationName:"ipAddress" type:"string"`
+	PrivateDNSName    *string                `locationName:"privateDnsName" type:"string"`
+	LaunchTime        *time.Time             `locationName:"launchTime" type:"timestamp" timestampFormat:"iso8601"`
+	Placement         *ec2Placement          `locationName:"placement" type:"structure"`
+	State             *ec2InstanceState      `locationName:"instanceState" type:"structure"`
+	Tags              []*ec2Tag              `locationName:"tagSet" locationNameList:"item" type:"list"`
+	NetworkInterfaces []*ec2NetworkInterface `locationName:"networkInterfaceSet" locationNameList:"item" type:"list"`
+}
+
+type ec2Placement struct {
+	AvailabilityZone *string `locationName:"availabilityZone" type:"string"`
+}
+
+type ec2InstanceState struct {
+	Name *string `locationName:"name" type:"string"`
+}
+
+type ec2Tag struct {
+	Key   *string `locationName:"key" type:"string"`
+	Value *string `locationName:"value" type:"string"`
+}
+
+type ec2NetworkInterface struct {
+	IPv6Addresses []*ec2IPv6Address `locationName:"ipv6AddressesSet" locationNameList:"item" type:"list"`
+}
+
+type ec2IPv6Address struct {
+	IPv6Address *string `locationName:"ipv6Address" type:"string"`
+}
+
+type ec2Reservation struct {
+	Instances []*ec2Instance `locationName:"instancesSet" locationNameList:"item" type:"list"`
+}
+
+type describeInstancesOutput struct {
+	NextToken    *string           `locationName:"nextToken" type:"string"`
+	Reservations []*ec2Reservation `locationName:"reservationSet" locationNameList:"item" type:"list"`
+}
+
+// describeEC2Instances returns every instance matching the input, following
+// the pagination tokens.
+func describeEC2Instances(input *ec2.DescribeInstancesInput, svcs *services) ([]*ec2Instance, error) {
+	op := &aws.Operation{
+		Name:       "DescribeInstances",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+
+	// don't modify the caller input when paginating
+	in := *input
+
+	instances := make([]*ec2Instance, 0)
+	for {
+		out := &describeInstancesOutput{}
+		if err := aws.NewRequest(svcs.ec2.Service, op, &in, out).Send(); err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range out.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+
+		if stringValue(out.NextToken) == "" {
+			break
+		}
+		in.NextToken = out.NextToken
+	}
+
+	return instances, nil
+}
+
+// newMember returns the cluster member backed by the given instance.
+func newMember(instance *ec2Instance) providers.Member {
+	member := providers.Member{
+		Id:             stringValue(instance.InstanceID),
+		PrivateAddress: stringValue(instance.PrivateIPAddress),
+		PublicAddress:  stringValue(instance.PublicIPAddress),
+		PrivateDNSName: stringValue(instance.PrivateDNSName),
+		Tags:           make(map[string]string),
+	}
+
+	if instance.LaunchTime != nil {
+		member.LaunchTime = *instance.LaunchTime
+	}
+
+	if instance.Placement != nil {
+		member.Zone = stringValue(instance.Placement.AvailabilityZone)
+	}
+
+	if instance.State != nil {
+		member.LifecycleState = lifecycleStateFromInstanceState(stringValue(instance.State.Name))
+	}
+
+	for _, tag := range instance.Tags {
+		member.Tags[stringValue(tag.Key)] = stringValue(tag.Value)
+	}
+
+	// the first address of the first interface having one
+	for _, networkInterface := range instance.NetworkInterfaces {
+		if len(networkInterface.IPv6Addresses) > 0 {
+			member.IPv6Address = stringValue(networkInterface.IPv6Addresses[0].IPv6Address)
+			break
+		}
+	}
+
+	return member
+}
+
+// lifecycleStateFromInstanceState maps EC2 instance states to their auto
+// scaling lifecycle state counterparts so both discoveries filter alike.
+func lifecycleStateFromInstanceState(state string) string {
+	switch state {
+	case "pending":
+		return "Pending"
+	case "running":
+		return "InService"
+	case "shutting-down", "stopping":
+		return "Terminating"
+	case "terminated", "stopped":
+		return "Terminated"
+	}
+	return ""
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+const (
+	lifecycleTransitionTerminating = "autoscaling:EC2_INSTANCE_TERMINATING"
+	lifecycleActionContinue        = "CONTINUE"
+
+	sqsMaxWaitTime = 20 * time.Second
+	sqsMaxMessages = 10
+
+	autoscalingMaxInstances = 50
+)
+
+type receiveMessageInput struct {
+	QueueUrl            *string `type:"string"`
+	MaxNumberOfMessages *int64  `type:"integer"`
+	WaitTimeSeconds     *int64  `type:"integer"`
+}
+
+type receiveMessageOutput struct {
+	Messages []*sqsMessage `locationName:"Message" type:"list" flattened:"true"`
+}
+
+type sqsMessage struct {
+	MessageId     *string `type:"string"`
+	ReceiptHandle *string `type:"string"`
+	Body          *string `type:"string"`
+}
+
+type deleteMessageInput struct {
+	QueueUrl      *string `type:"string"`
+	ReceiptHandle *string `type:"string"`
+}
+
+type deleteMessageOutput struct{}
+
+// lifecycleNotification is the message auto scaling sends to the lifecycle
+// hook notification target. Test notifications have no transition.
+type lifecycleNotification struct {
+	LifecycleTransition  string
+	LifecycleHookName    string
+	LifecycleActionToken string
+	AutoScalingGroupName string
+	EC2InstanceId        string
+}
+
+// snsNotification wraps notifications delivered to the queue through SNS.
+type snsNotification struct {
+	Type    string
+	Message string
+}
+
+// lifecycleHandle identifies a received termination, its message is deleted
+// once the lifecycle action is completed.
+type lifecycleHandle struct {
+	notification  lifecycleNotification
+	receiptHandle string
+}
+
+// newSQSService returns a client for the SQS Query api. The vendored SDK
+// doesn't ship the sqs service, its handlers are borrowed from the auto
+// scaling client as done for STS.
+func newSQSService(config *aws.Config) *aws.Service {
+	service := *autoscaling.New(config).Service
+	service.ServiceName = "sqs"
+	service.APIVersion = "2012-11-05"
+	service.Endpoint = fmt.Sprintf("https://sqs.%s.amazonaws.com", config.Region)
+	service.SigningRegion = config.Region
+	if config.Endpoint != "" {
+		service.Endpoint = config.Endpoint
+	}
+	return &service
+}
+
+// ReceiveTerminations long polls the lifecycle queue for instances being
+// terminated. Other notifications of the cluster (test notifications or
+// launching transitions) are deleted, while the ones of other groups or hooks
+// and unparseable messages are left alone for whoever shares the queue.
+func (a *Aws) ReceiveTerminations(wait time.Duration) ([]providers.Termination, error) {
+	if a.config.LifecycleQueueURL == "" {
+		return nil, errors.New("no lifecycle queue url configured")
+	}
+
+	if wait > sqsMaxWaitTime {
+		wait = sqsMaxWaitTime
+	}
+
+	svcs, err := a.newServices()
+	if err != nil {
+		return nil, err
+	}
+
+	output := &receiveMessageOutput{}
+	op := &aws.Operation{
+		Name:       "ReceiveMessage",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	input := &receiveMessageInput{
+		QueueUrl:            aws.String(a.config.LifecycleQueueURL),
+		MaxNumberOfMessages: aws.Long(sqsMaxMessages),
+		WaitTimeSeconds:     aws.Long(int64(wait / time.Second)),
+	}
+	if err := aws.NewRequest(svcs.sqs, op, input, output).Send(); err != nil {
+		return nil, err
+	}
+
+	// only looked up if there is any notification
+	var clusterGroups map[string]bool
+
+	terminations := make([]providers.Termination, 0)
+	for _, message := range output.Messages {
+		receiptHandle := stringValue(message.ReceiptHandle)
+
+		notification, err := parseLifecycleNotification(stringValue(message.Body))
+		if err != nil {
+			log.Printf("ignoring message %s: %v\n", stringValue(message.MessageId), err)
+			continue
+		}
+
+		if clusterGroups == nil {
+			if clusterGroups, err = a.findClusterAutoscalingGroupNames(svcs); err != nil {
+				return nil, err
+			}
+		}
+
+		if !a.isClusterNotification(notification, clusterGroups) {
+			log.Printf("ignoring message %s of group %s and hook %s\n", stringValue(message.MessageId), notification.AutoScalingGroupName, notification.LifecycleHookName)
+			continue
+		}
+
+		if notification.LifecycleTransition != lifecycleTransitionTerminating || notification.EC2InstanceId == "" {
+			if err := deleteSQSMessage(svcs, a.config.LifecycleQueueURL, receiptHandle); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		terminations = append(terminations, providers.Termination{
+			InstanceId: notification.EC2InstanceId,
+			Handle: lifecycleHandle{
+				notification:  notification,
+				receiptHandle: receiptHandle,
+			},
+		})
+	}
+
+	return terminations, nil
+}
+
+// CompleteTermination lets auto scaling go on with the termination and
+// deletes its message. Lifecycle actions which already timed out are only
+// deleted.
+func (a *Aws) CompleteTermination(termination providers.Termination) error {
+	handle, ok := termination.Handle.(lifecycleHandle)
+	if !ok {
+		return fmt.Errorf("unknown termination of %s", termination.InstanceId)
+	}
+
+	svcs, err := a.newServices()
+	if err != nil {
+		return err
+	}
+
+	notification := handle.notification
+	_, err = svcs.autoscaling.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(notification.AutoScalingGroupName),
+		LifecycleActionResult: aws.String(lifecycleActionContinue),
+		LifecycleActionToken:  aws.String(notification.LifecycleActionToken),
+		LifecycleHookName:     aws.String(notification.LifecycleHookName),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ValidationError" {
+		log.Printf("unable to complete lifecycle action of %s: %v\n", termination.InstanceId, err)
+	} else if err != nil {
+		return err
+	}
+
+	return deleteSQSMessage(svcs, a.config.LifecycleQueueURL, handle.receiptHandle)
+}
+
+// findClusterAutoscalingGroupNames returns the names of the auto scaling
+// groups of the cluster. Clusters discovered by tags span the groups of every
+// tagged instance.
+func (a *Aws) findClusterAutoscalingGroupNames(svcs *services) (map[string]bool, error) {
+	if a.config.Discovery == DiscoveryTags {
+		members, err := a.getClusterMembersByTag("")
+		if err != nil {
+			return nil, err
+		}
+
+		instanceIds := make([]*string, 0)
+		for id := range members {
+			instanceIds = append(instanceIds, aws.String(id))
+		}
+
+		// at most 50 instances are described per request
+		names := make(map[string]bool)
+		for len(instanceIds) > 0 {
+			batch := instanceIds
+			if len(batch) > autoscalingMaxInstances {
+				batch = batch[:autoscalingMaxInstances]
+			}
+			instanceIds = instanceIds[len(batch):]
+
+			out, err := svcs.autoscaling.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+				InstanceIDs: batch,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range out.AutoScalingInstances {
+				names[stringValue(i.AutoScalingGroupName)] = true
+			}
+		}
+		return names, nil
+	}
+
+	autoscalingGroups, err := a.findClusterAutoscalingGroups(svcs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, asg := range autoscalingGroups {
+		names[stringValue(asg.AutoScalingGroupName)] = true
+	}
+	return names, nil
+}
+
+// isClusterNotification tells whether the notification was sent by the given
+// cluster groups and, if configured, the lifecycle hook. Test notifications
+// carry no hook name.
+func (a *Aws) isClusterNotification(notification lifecycleNotification, clusterGroups map[string]bool) bool {
+	if !clusterGroups[notification.AutoScalingGroupName] {
+		return false
+	}
+
+	if a.config.LifecycleHookName == "" || notification.LifecycleTransition == "" {
+		return true
+	}
+
+	return notification.LifecycleHookName == a.config.LifecycleHookName
+}
+
+func parseLifecycleNotification(body string) (lifecycleNotification, error) {
+	var sns snsNotification
+	if err := json.Unmarshal([]byte(body), &sns); err == nil && sns.Type == "Notification" {
+		body = sns.Message
+	}
+
+	var notification lifecycleNotification
+	if err := json.Unmarshal([]byte(body), &notification); err != nil {
+		return lifecycleNotification{}, err
+	}
+
+	return notification, nil
+}
+
+func deleteSQSMessage(svcs *services, queueURL, receiptHandle string) error {
+	op := &aws.Operation{
+		Name:       "DeleteMessage",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	input := &deleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	}
+	return aws.NewRequest(svcs.sqs, op, input, &deleteMessageOutput{}).Send()
+}
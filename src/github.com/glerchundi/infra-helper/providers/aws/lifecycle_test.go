@@ -0,0 +1,174 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLifecycleNotifications are the bodies of the messages in the lifecycle
+// queue by receipt handle.
+var fakeLifecycleNotifications = map[string]string{
+	// direct and through SNS
+	"r1": `{"LifecycleTransition": "autoscaling:EC2_INSTANCE_TERMINATING", "LifecycleHookName": "etcd", "LifecycleActionToken": "t1", "AutoScalingGroupName": "etcd-a", "EC2InstanceId": "i-1"}`,
+	"r2": snsWrapped(`{"LifecycleTransition": "autoscaling:EC2_INSTANCE_TERMINATING", "LifecycleHookName": "etcd", "LifecycleActionToken": "t2", "AutoScalingGroupName": "etcd-a", "EC2InstanceId": "i-2"}`),
+	// the cluster ones which aren't terminations
+	"r3": `{"Event": "autoscaling:TEST_NOTIFICATION", "AutoScalingGroupName": "etcd-a"}`,
+	"r4": `{"LifecycleTransition": "autoscaling:EC2_INSTANCE_LAUNCHING", "LifecycleHookName": "etcd", "AutoScalingGroupName": "etcd-a", "EC2InstanceId": "i-5"}`,
+	// the ones of others
+	"r5": `{"LifecycleTransition": "autoscaling:EC2_INSTANCE_TERMINATING", "LifecycleHookName": "web", "AutoScalingGroupName": "web", "EC2InstanceId": "i-9"}`,
+	"r6": `{"LifecycleTransition": "autoscaling:EC2_INSTANCE_TERMINATING", "LifecycleHookName": "drain", "AutoScalingGroupName": "etcd-a", "EC2InstanceId": "i-1"}`,
+	"r7": `not a notification`,
+}
+
+func snsWrapped(message string) string {
+	body, err := json.Marshal(snsNotification{Type: "Notification", Message: message})
+	if err != nil {
+		panic(err)
+	}
+	return string(body)
+}
+
+func fakeLifecycleMessages() string {
+	receiptHandles := make([]string, 0)
+	for receiptHandle := range fakeLifecycleNotifications {
+		receiptHandles = append(receiptHandles, receiptHandle)
+	}
+	sort.Strings(receiptHandles)
+
+	messages := ""
+	for _, receiptHandle := range receiptHandles {
+		messages += fmt.Sprintf("<Message><MessageId>m-%s</MessageId><ReceiptHandle>%s</ReceiptHandle><Body>%s</Body></Message>",
+			receiptHandle, receiptHandle, html.EscapeString(fakeLifecycleNotifications[receiptHandle]))
+	}
+	return messages
+}
+
+// deletedMessages returns the receipt handles of the deleted messages.
+func deletedMessages(requests []string) []string {
+	deleted := make([]string, 0)
+	for _, request := range requests {
+		if strings.Contains(request, "Action=DeleteMessage") {
+			for _, param := range strings.Split(request, "&") {
+				if strings.HasPrefix(param, "ReceiptHandle=") {
+					deleted = append(deleted, strings.TrimPrefix(param, "ReceiptHandle="))
+				}
+			}
+		}
+	}
+	sort.Strings(deleted)
+	return deleted
+}
+
+func TestParseLifecycleNotification(t *testing.T) {
+	for _, body := range []string{fakeLifecycleNotifications["r1"], snsWrapped(fakeLifecycleNotifications["r1"])} {
+		notification, err := parseLifecycleNotification(body)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", body, err)
+			continue
+		}
+		expected := lifecycleNotification{
+			LifecycleTransition:  lifecycleTransitionTerminating,
+			LifecycleHookName:    "etcd",
+			LifecycleActionToken: "t1",
+			AutoScalingGroupName: "etcd-a",
+			EC2InstanceId:        "i-1",
+		}
+		if notification != expected {
+			t.Errorf("%s: parsed %+v", body, notification)
+		}
+	}
+
+	if _, err := parseLifecycleNotification(fakeLifecycleNotifications["r7"]); err == nil {
+		t.Errorf("expected an error for a message which isn't a notification")
+	}
+}
+
+func TestReceiveTerminations(t *testing.T) {
+	tests := []struct {
+		hookName    string
+		terminating []string
+		deleted     []string
+	}{
+		{
+			hookName:    "",
+			terminating: []string{"i-1", "i-2", "i-1"},
+			deleted:     []string{"r3", "r4"},
+		},
+		{
+			hookName:    "etcd",
+			terminating: []string{"i-1", "i-2"},
+			deleted:     []string{"r3", "r4"},
+		},
+	}
+
+	for _, test := range tests {
+		server, aws, requests := newFakeAws(t, false)
+		aws.config.LifecycleHookName = test.hookName
+
+		terminations, err := aws.ReceiveTerminations(time.Minute)
+		server.Close()
+		if err != nil {
+			t.Errorf("hook %q: unexpected error: %v", test.hookName, err)
+			continue
+		}
+
+		terminating := make([]string, 0)
+		for _, termination := range terminations {
+			terminating = append(terminating, termination.InstanceId)
+		}
+		if !reflect.DeepEqual(terminating, test.terminating) {
+			t.Errorf("hook %q: terminating %v, expected %v", test.hookName, terminating, test.terminating)
+		}
+
+		// messages of other groups or hooks are left alone
+		if deleted := deletedMessages(*requests); !reflect.DeepEqual(deleted, test.deleted) {
+			t.Errorf("hook %q: deleted %v, expected %v", test.hookName, deleted, test.deleted)
+		}
+
+		for _, request := range *requests {
+			if strings.Contains(request, "Action=ReceiveMessage") && !strings.Contains(request, "WaitTimeSeconds=20") {
+				t.Errorf("hook %q: wait time not capped: %s", test.hookName, request)
+			}
+		}
+	}
+}
+
+func TestCompleteTermination(t *testing.T) {
+	server, aws, requests := newFakeAws(t, false)
+	defer server.Close()
+
+	aws.config.LifecycleHookName = "etcd"
+	terminations, err := aws.ReceiveTerminations(time.Second)
+	if err != nil || len(terminations) != 2 {
+		t.Fatalf("unexpected terminations %v, %v", terminations, err)
+	}
+
+	if err := aws.CompleteTermination(terminations[1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	completed := false
+	for _, request := range *requests {
+		if strings.Contains(request, "Action=CompleteLifecycleAction") && strings.Contains(request, "LifecycleActionToken=t2") &&
+			strings.Contains(request, "LifecycleHookName=etcd") && strings.Contains(request, "AutoScalingGroupName=etcd-a") &&
+			strings.Contains(request, "LifecycleActionResult=CONTINUE") {
+			completed = true
+		}
+	}
+	if !completed {
+		t.Errorf("lifecycle action not completed: %v", *requests)
+	}
+
+	if deleted := deletedMessages(*requests); !reflect.DeepEqual(deleted, []string{"r2", "r3", "r4"}) {
+		t.Errorf("deleted %v, expected [r2 r3 r4]", deleted)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package aws
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/glerchundi/infra-helper/util"
+)
+
+const (
+	DefaultMetadataEndpoint = "http://169.254.169.254"
+
+	metadataTokenTTL    = 6 * time.Hour
+	metadataTokenWindow = 1 * time.Minute
+)
+
+// metadataClient retrieves instance metadata using IMDSv2 session tokens,
+// falling back to unauthenticated IMDSv1 requests only if allowed.
+type metadataClient struct {
+	endpoint string
+	allowV1  bool
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newMetadataClient(endpoint string, allowV1 bool) *metadataClient {
+	return &metadataClient{
+		endpoint: endpoint,
+		allowV1:  allowV1,
+	}
+}
+
+// get returns the metadata under the given path, i.e. "instance-id".
+func (m *metadataClient) get(path string) (string, error) {
+	url := m.endpoint + "/latest/meta-data/" + path
+
+	token, err := m.getToken(false)
+	if err != nil {
+		if !m.allowV1 {
+			return "", err
+		}
+		log.Printf("unable to get metadata token, falling back to IMDSv1: %v\n", err)
+		return util.HttpGet(url)
+	}
+
+	out, err := m.getWithToken(url, token)
+	if err == nil {
+		return out, nil
+	}
+
+	// the token may have been invalidated, retry once with a fresh one
+	token, tokenErr := m.getToken(true)
+	if tokenErr != nil {
+		return "", err
+	}
+
+	return m.getWithToken(url, token)
+}
+
+func (m *metadataClient) getWithToken(url, token string) (string, error) {
+	return util.HttpRequest("GET", url, map[string]string{
+		"X-aws-ec2-metadata-token": token,
+	})
+}
+
+// getToken returns the cached session token unless it is about to expire or
+// a refresh is forced.
+func (m *metadataClient) getToken(refresh bool) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !refresh && m.token != "" && time.Now().Add(metadataTokenWindow).Before(m.expires) {
+		return m.token, nil
+	}
+
+	token, err := util.HttpRequest("PUT", m.endpoint+"/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": fmt.Sprintf("%d", int(metadataTokenTTL.Seconds())),
+	})
+	if err != nil {
+		m.token = ""
+		return "", err
+	}
+
+	m.token = token
+	m.expires = time.Now().Add(metadataTokenTTL)
+
+	return m.token, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package aws
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	DefaultSTSEndpoint = "https://sts.amazonaws.com"
+
+	assumeRoleDuration = 15 * time.Minute
+	assumeRoleWindow   = 1 * time.Minute
+)
+
+type assumeRoleInput struct {
+	RoleArn         *string `type:"string"`
+	RoleSessionName *string `type:"string"`
+	ExternalId      *string `type:"string"`
+	DurationSeconds *int64  `type:"integer"`
+}
+
+type assumeRoleOutput struct {
+	Credentials *struct {
+		AccessKeyId     *string    `type:"string"`
+		SecretAccessKey *string    `type:"string"`
+		SessionToken    *string    `type:"string"`
+		Expiration      *time.Time `type:"timestamp" timestampFormat:"iso8601"`
+	} `type:"structure"`
+}
+
+// newSTSService returns a client for the STS Query api. The vendored SDK
+// doesn't ship the sts service and its protocol and signer packages are
+// internal, so the handlers are borrowed from the auto scaling client which
+// speaks the same Query protocol.
+func newSTSService(config *aws.Config) *aws.Service {
+	service := *autoscaling.New(config).Service
+	service.ServiceName = "sts"
+	service.APIVersion = "2011-06-15"
+	service.Endpoint = DefaultSTSEndpoint
+	service.SigningRegion = "us-east-1"
+	if config.Endpoint != "" {
+		// regional and VPC endpoints are signed for their region, the
+		// global one is assumed if none is configured
+		service.Endpoint = config.Endpoint
+		if config.Region != "" {
+			service.SigningRegion = config.Region
+		}
+	}
+	return &service
+}
+
+// assumeRoleProvider retrieves temporary credentials for a role through STS
+// AssumeRole and keeps track of their expiration time.
+type assumeRoleProvider struct {
+	credentials.Expiry
+
+	service         *aws.Service
+	roleARN         string
+	roleSessionName string
+	externalID      string
+}
+
+// newAssumeRoleCredentials returns credentials of the given role, the source
+// credentials, region and STS endpoint are taken from config.
+func newAssumeRoleCredentials(config *aws.Config, roleARN, roleSessionName, externalID string) *credentials.Credentials {
+	return credentials.NewCredentials(&assumeRoleProvider{
+		service:         newSTSService(config),
+		roleARN:         roleARN,
+		roleSessionName: roleSessionName,
+		externalID:      externalID,
+	})
+}
+
+func (p *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+	input := &assumeRoleInput{
+		RoleArn:         aws.String(p.roleARN),
+		RoleSessionName: aws.String(p.roleSessionName),
+		DurationSeconds: aws.Long(int64(assumeRoleDuration / time.Second)),
+	}
+	if p.externalID != "" {
+		input.ExternalId = aws.String(p.externalID)
+	}
+
+	output := &assumeRoleOutput{}
+	op := &aws.Operation{
+		Name:       "AssumeRole",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	if err := aws.NewRequest(p.service, op, input, output).Send(); err != nil {
+		return credentials.Value{}, err
+	}
+
+	creds := output.Credentials
+	if creds == nil || creds.AccessKeyId == nil || creds.SecretAccessKey == nil {
+		return credentials.Value{}, errors.New("failed to assume role: no credentials returned")
+	}
+
+	expiration := time.Now().Add(assumeRoleDuration)
+	if creds.Expiration != nil {
+		expiration = *creds.Expiration
+	}
+	p.SetExpiration(expiration, assumeRoleWindow)
+
+	return credentials.Value{
+		AccessKeyID:     *creds.AccessKeyId,
+		SecretAccessKey: *creds.SecretAccessKey,
+		SessionToken:    stringValue(creds.SessionToken),
+	}, nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+var credentialScope = regexp.MustCompile(`Credential=id/\d{8}/([^/]*)/sts/aws4_request`)
+
+// newFakeSTS serves AssumeRole, reporting the region requests were signed for.
+func newFakeSTS(t *testing.T, signingRegion *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := credentialScope.FindStringSubmatch(r.Header.Get("Authorization"))
+		if match == nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		*signingRegion = match[1]
+
+		r.ParseForm()
+		if r.PostForm.Get("Action") != "AssumeRole" || r.PostForm.Get("RoleArn") != "arn:aws:iam::123:role/etcd" ||
+			r.PostForm.Get("ExternalId") != "external" {
+			t.Logf("unexpected request: %v", r.PostForm)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>
+			<AccessKeyId>assumed</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>
+			<Expiration>2099-10-01T10:00:00Z</Expiration>
+		</Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+	}))
+}
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	for region, expected := range map[string]string{"": "us-east-1", "eu-west-1": "eu-west-1"} {
+		var signingRegion string
+		server := newFakeSTS(t, &signingRegion)
+
+		creds := newAssumeRoleCredentials(&aws.Config{
+			Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+			Endpoint:    server.URL,
+			Region:      region,
+		}, "arn:aws:iam::123:role/etcd", "infra-helper", "external")
+
+		value, err := creds.Get()
+		server.Close()
+		if err != nil {
+			t.Errorf("region %q: unexpected error: %v", region, err)
+			continue
+		}
+
+		if value.AccessKeyID != "assumed" || value.SecretAccessKey != "secret" || value.SessionToken != "session" {
+			t.Errorf("region %q: unexpected credentials: %+v", region, value)
+		}
+		if signingRegion != expected {
+			t.Errorf("region %q: signed for %q, expected %q", region, signingRegion, expected)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package azure
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+const (
+	DefaultMetadataURL = "http://169.254.169.254/metadata"
+	DefaultApiURL      = "https://management.azure.com"
+
+	metadataApiVersion = "2017-08-01"
+	tokenApiVersion    = "2018-02-01"
+	computeApiVersion  = "2017-03-30"
+)
+
+func init() {
+	providers.Register(
+		"azure",
+		[]cli.Flag{
+			cli.StringFlag{
+				Name: "azure-metadata-url",
+				Value: DefaultMetadataURL,
+				Usage: "Azure instance metadata service base url",
+				EnvVar: "INFRA_HELPER_AZURE_METADATA_URL",
+			},
+			cli.StringFlag{
+				Name: "azure-api-url",
+				Value: DefaultApiURL,
+				Usage: "Azure resource manager api base url",
+				EnvVar: "INFRA_HELPER_AZURE_API_URL",
+			},
+		},
+		func(c *cli.Context) (providers.Provider, error) {
+			return New(c.GlobalString("azure-metadata-url"), c.GlobalString("azure-api-url")), nil
+		},
+	)
+}
+
+func New(metadataURL, apiURL string) *Azure {
+	return &Azure{
+		metadataURL: strings.TrimRight(metadataURL, "/"),
+		apiURL:      strings.TrimRight(apiURL, "/"),
+	}
+}
+
+type Azure struct {
+	metadataURL string
+	apiURL      string
+}
+
+type instanceMetadata struct {
+	Compute struct {
+		Name              string `json:"name"`
+		ResourceGroupName string `json:"resourceGroupName"`
+		SubscriptionId    string `json:"subscriptionId"`
+		VmScaleSetName    string `json:"vmScaleSetName"`
+	} `json:"compute"`
+	Network struct {
+		Interface []struct {
+			Ipv4 struct {
+				IpAddress []struct {
+					PrivateIpAddress string `json:"privateIpAddress"`
+				} `json:"ipAddress"`
+			} `json:"ipv4"`
+		} `json:"interface"`
+	} `json:"network"`
+}
+
+type virtualMachine struct {
+	Id         string            `json:"id"`
+	Name       string            `json:"name"`
+	Zones      []string          `json:"zones"`
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		TimeCreated string `json:"timeCreated"`
+		OsProfile   struct {
+			ComputerName string `json:"computerName"`
+		} `json:"osProfile"`
+	} `json:"properties"`
+}
+
+type networkInterface struct {
+	Properties struct {
+		Primary          bool `json:"primary"`
+		IpConfigurations []struct {
+			Properties struct {
+				Primary                 bool   `json:"primary"`
+				PrivateIPAddress        string `json:"privateIPAddress"`
+				PrivateIPAddressVersion string `json:"privateIPAddressVersion"`
+			} `json:"properties"`
+		} `json:"ipConfigurations"`
+		VirtualMachine struct {
+			Id string `json:"id"`
+		} `json:"virtualMachine"`
+	} `json:"properties"`
+}
+
+func (azure *Azure) GetInstanceId() (string, error) {
+	metadata, err := azure.getInstanceMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	return metadata.Compute.Name, nil
+}
+
+func (azure *Azure) GetInstancePrivateAddress() (string, error) {
+	metadata, err := azure.getInstanceMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	for _, iface := range metadata.Network.Interface {
+		for _, address := range iface.Ipv4.IpAddress {
+			return address.PrivateIpAddress, nil
+		}
+	}
+
+	return "", errors.New("failed to get the private address")
+}
+
+func (azure *Azure) GetClusterMembers() (map[string]providers.Member, error) {
+	metadata, err := azure.getInstanceMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Compute.VmScaleSetName == "" {
+		return nil, errors.New("failed to get the virtual machine scale set name")
+	}
+
+	return azure.GetClusterMembersByName(metadata.Compute.VmScaleSetName)
+}
+
+func (azure *Azure) GetClusterMembersByName(name string) (map[string]providers.Member, error) {
+	metadata, err := azure.getInstanceMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := azure.getAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	scaleSetPath := fmt.Sprintf(
+		"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s",
+		metadata.Compute.SubscriptionId, metadata.Compute.ResourceGroupName, name,
+	)
+
+	// Scale set virtual machines
+	virtualMachines := make(map[string]*providers.Member)
+	err = azure.listApi(scaleSetPath+"/virtualMachines", token, func(value json.RawMessage) error {
+		var vm virtualMachine
+		if err := json.Unmarshal(value, &vm); err != nil {
+			return err
+		}
+		virtualMachines[strings.ToLower(vm.Id)] = newMember(vm)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Scale set network interfaces
+	isPrimary := make(map[string]bool)
+	err = azure.listApi(scaleSetPath+"/networkInterfaces", token, func(value json.RawMessage) error {
+		var nic networkInterface
+		if err := json.Unmarshal(value, &nic); err != nil {
+			return err
+		}
+
+		member, ok := virtualMachines[strings.ToLower(nic.Properties.VirtualMachine.Id)]
+		if !ok {
+			return nil
+		}
+
+		// primary interfaces and ip configurations take precedence
+		for _, ipConfiguration := range nic.Properties.IpConfigurations {
+			address := ipConfiguration.Properties.PrivateIPAddress
+			if ipConfiguration.Properties.PrivateIPAddressVersion == "IPv6" {
+				if member.IPv6Address == "" {
+					member.IPv6Address = address
+				}
+				continue
+			}
+			if member.PrivateAddress == "" || (nic.Properties.Primary && ipConfiguration.Properties.Primary && !isPrimary[member.Id]) {
+				member.PrivateAddress = address
+				isPrimary[member.Id] = nic.Properties.Primary && ipConfiguration.Properties.Primary
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]providers.Member)
+	for _, member := range virtualMachines {
+		if member.PrivateAddress == "" {
+			continue
+		}
+		members[member.Id] = *member
+	}
+
+	return members, nil
+}
+
+// newMember returns the cluster member backed by the given scale set
+// virtual machine, addresses are filled from its network interfaces.
+func newMember(vm virtualMachine) *providers.Member {
+	member := &providers.Member{
+		Id:             vm.Name,
+		PrivateDNSName: vm.Properties.OsProfile.ComputerName,
+		Tags:           make(map[string]string),
+	}
+
+	if len(vm.Zones) > 0 {
+		member.Zone = vm.Zones[0]
+	}
+
+	if launchTime, err := time.Parse(time.RFC3339, vm.Properties.TimeCreated); err == nil {
+		member.LaunchTime = launchTime
+	}
+
+	for key, value := range vm.Tags {
+		member.Tags[key] = value
+	}
+
+	return member
+}
+
+func (azure *Azure) getInstanceMetadata() (*instanceMetadata, error) {
+	out, err := util.HttpRequest("GET", azure.metadataURL+"/instance?api-version="+metadataApiVersion, map[string]string{
+		"Metadata": "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &instanceMetadata{}
+	if err := json.Unmarshal([]byte(out), metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func (azure *Azure) getAccessToken() (string, error) {
+	tokenURL := fmt.Sprintf(
+		"%s/identity/oauth2/token?api-version=%s&resource=%s",
+		azure.metadataURL, tokenApiVersion, url.QueryEscape(DefaultApiURL+"/"),
+	)
+
+	out, err := util.HttpRequest("GET", tokenURL, map[string]string{
+		"Metadata": "true",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal([]byte(out), &token); err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
+
+// listApi calls fn for every value of an ARM list operation, following
+// nextLink until all pages are consumed.
+func (azure *Azure) listApi(path, token string, fn func(json.RawMessage) error) error {
+	nextLink := azure.apiURL + path + "?api-version=" + computeApiVersion
+	for nextLink != "" {
+		out, err := util.HttpRequest("GET", nextLink, map[string]string{
+			"Authorization": "Bearer " + token,
+		})
+		if err != nil {
+			return err
+		}
+
+		var page struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"nextLink"`
+		}
+		if err := json.Unmarshal([]byte(out), &page); err != nil {
+			return err
+		}
+
+		for _, value := range page.Value {
+			if err := fn(value); err != nil {
+				return err
+			}
+		}
+
+		nextLink = page.NextLink
+	}
+
+	return nil
+}
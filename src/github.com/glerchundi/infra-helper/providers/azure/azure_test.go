@@ -0,0 +1,114 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	fakeInstanceMetadata = `{
+		"compute": {"name": "etcd_0", "resourceGroupName": "group", "subscriptionId": "sub", "vmScaleSetName": "etcd"},
+		"network": {"interface": [{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4"}]}}]}
+	}`
+
+	fakeScaleSetPath = "/api/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/etcd"
+)
+
+// newFakeAzure serves the instance metadata service under /metadata and the
+// resource manager api under /api, virtual machines are paginated.
+func newFakeAzure(t *testing.T) (*httptest.Server, *Azure) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metadata/instance" || r.URL.Path == "/metadata/identity/oauth2/token" {
+			if r.Header.Get("Metadata") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.URL.Path == "/metadata/instance" {
+				fmt.Fprint(w, fakeInstanceMetadata)
+			} else {
+				fmt.Fprint(w, `{"access_token": "token"}`)
+			}
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == fakeScaleSetPath+"/virtualMachines" && r.URL.Query().Get("page") == "":
+			fmt.Fprintf(w, `{"value": [{
+				"id": "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/etcd/virtualMachines/0",
+				"name": "etcd_0", "zones": ["1"], "tags": {"role": "etcd"},
+				"properties": {"timeCreated": "2015-10-01T10:00:00Z", "osProfile": {"computerName": "etcd000000"}}
+			}], "nextLink": "%s%s/virtualMachines?api-version=%s&page=2"}`, server.URL, fakeScaleSetPath, computeApiVersion)
+		case r.URL.Path == fakeScaleSetPath+"/virtualMachines":
+			fmt.Fprint(w, `{"value": [{
+				"id": "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/etcd/virtualMachines/1",
+				"name": "etcd_1", "properties": {"osProfile": {"computerName": "etcd000001"}}
+			}]}`)
+		case r.URL.Path == fakeScaleSetPath+"/networkInterfaces":
+			fmt.Fprint(w, `{"value": [
+				{"properties": {"primary": false, "virtualMachine": {"id": "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/etcd/virtualMachines/0"},
+					"ipConfigurations": [{"properties": {"primary": true, "privateIPAddress": "10.1.0.4", "privateIPAddressVersion": "IPv4"}}]}},
+				{"properties": {"primary": true, "virtualMachine": {"id": "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/etcd/virtualMachines/0"},
+					"ipConfigurations": [
+						{"properties": {"primary": true, "privateIPAddress": "10.0.0.4", "privateIPAddressVersion": "IPv4"}},
+						{"properties": {"primary": false, "privateIPAddress": "fd00::4", "privateIPAddressVersion": "IPv6"}}
+					]}},
+				{"properties": {"primary": true, "virtualMachine": {"id": "/SUBSCRIPTIONS/SUB/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/etcd/virtualMachines/1"},
+					"ipConfigurations": [{"properties": {"primary": true, "privateIPAddress": "10.0.0.5", "privateIPAddressVersion": "IPv4"}}]}}
+			]}`)
+		default:
+			t.Logf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, New(server.URL+"/metadata", server.URL+"/api")
+}
+
+func TestGetInstance(t *testing.T) {
+	server, azure := newFakeAzure(t)
+	defer server.Close()
+
+	if id, err := azure.GetInstanceId(); err != nil || id != "etcd_0" {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+	if ip, err := azure.GetInstancePrivateAddress(); err != nil || ip != "10.0.0.4" {
+		t.Errorf("GetInstancePrivateAddress() = %q, %v", ip, err)
+	}
+}
+
+func TestGetClusterMembers(t *testing.T) {
+	server, azure := newFakeAzure(t)
+	defer server.Close()
+
+	members, err := azure.GetClusterMembers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(members) != 2 {
+		t.Fatalf("expected 2 members, got %v", members)
+	}
+
+	first := members["etcd_0"]
+	if first.PrivateAddress != "10.0.0.4" || first.IPv6Address != "fd00::4" || first.PrivateDNSName != "etcd000000" ||
+		first.Zone != "1" || first.Tags["role"] != "etcd" || !first.LaunchTime.Equal(time.Date(2015, 10, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected member: %+v", first)
+	}
+
+	second := members["etcd_1"]
+	if second.PrivateAddress != "10.0.0.5" || second.PrivateDNSName != "etcd000001" {
+		t.Errorf("unexpected member: %+v", second)
+	}
+}
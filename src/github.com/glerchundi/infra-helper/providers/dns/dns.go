@@ -0,0 +1,206 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+const (
+	RecordTypeSRV = "srv"
+	RecordTypeA   = "a"
+)
+
+var (
+	// indirection for testing
+	resolveSRV     = net.LookupSRV
+	resolveIP      = net.LookupIP
+	resolveAddr    = net.LookupAddr
+	interfaceAddrs = net.InterfaceAddrs
+)
+
+func init() {
+	providers.Register(
+		"dns",
+		[]cli.Flag{
+			cli.StringFlag{
+				Name: "dns-name",
+				Usage: "domain publishing _etcd-server._tcp SRV records or hostname with one A record per peer",
+				EnvVar: "INFRA_HELPER_DNS_NAME",
+			},
+			cli.StringFlag{
+				Name: "dns-record-type",
+				Value: RecordTypeSRV,
+				Usage: "records used to discover peers (srv, a)",
+				EnvVar: "INFRA_HELPER_DNS_RECORD_TYPE",
+			},
+		},
+		func(c *cli.Context) (providers.Provider, error) {
+			return New(c.GlobalString("dns-name"), c.GlobalString("dns-record-type"))
+		},
+	)
+}
+
+func New(name, recordType string) (*Dns, error) {
+	if recordType != RecordTypeSRV && recordType != RecordTypeA {
+		return nil, fmt.Errorf("unknown dns record type: %s", recordType)
+	}
+
+	return &Dns{
+		name:       name,
+		recordType: recordType,
+	}, nil
+}
+
+type Dns struct {
+	name       string
+	recordType string
+}
+
+func (dns *Dns) GetInstanceId() (string, error) {
+	instanceId, _, err := dns.findSelf()
+	return instanceId, err
+}
+
+func (dns *Dns) GetInstancePrivateAddress() (string, error) {
+	_, localIp, err := dns.findSelf()
+	return localIp, err
+}
+
+func (dns *Dns) GetClusterMembers() (map[string]providers.Member, error) {
+	if dns.name == "" {
+		return nil, errors.New("dns name not specified")
+	}
+
+	return dns.GetClusterMembersByName(dns.name)
+}
+
+func (dns *Dns) GetClusterMembersByName(name string) (map[string]providers.Member, error) {
+	if dns.recordType == RecordTypeA {
+		return lookupA(name)
+	}
+	return lookupSRV(name)
+}
+
+// findSelf identifies this instance as the cluster member whose address is
+// bound to one of the local interfaces.
+func (dns *Dns) findSelf() (string, string, error) {
+	members, err := dns.GetClusterMembers()
+	if err != nil {
+		return "", "", err
+	}
+
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		for memberName, member := range members {
+			if member.PrivateAddress == ipNet.IP.String() {
+				return memberName, member.PrivateAddress, nil
+			}
+		}
+	}
+
+	return "", "", errors.New("failed to identify this instance in dns records")
+}
+
+// lookupSRV resolves _etcd-server-ssl._tcp and _etcd-server._tcp records,
+// members are named after the SRV targets.
+func lookupSRV(domain string) (map[string]providers.Member, error) {
+	targets := make([]string, 0)
+	errs := make([]string, 0)
+	for _, service := range []string{"etcd-server-ssl", "etcd-server"} {
+		_, addrs, err := resolveSRV(service, "tcp", domain)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, srv := range addrs {
+			targets = append(targets, srv.Target)
+		}
+	}
+
+	// either of them is enough
+	if len(errs) == 2 {
+		return nil, fmt.Errorf("dns lookup errors: %s", strings.Join(errs, " and "))
+	}
+
+	members := make(map[string]providers.Member)
+	for _, target := range targets {
+		// targets may be published for both schemes
+		host := strings.TrimSuffix(target, ".")
+		if _, ok := members[host]; ok {
+			continue
+		}
+
+		ip, err := lookupIPv4(target)
+		if err != nil {
+			return nil, err
+		}
+
+		members[host] = providers.Member{
+			Id:             host,
+			PrivateAddress: ip,
+			PrivateDNSName: host,
+		}
+	}
+
+	return members, nil
+}
+
+// lookupA resolves every address of the given host, members are named after
+// the reverse lookup of their address if any.
+func lookupA(host string) (map[string]providers.Member, error) {
+	ips, err := resolveIP(host)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]providers.Member)
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			continue
+		}
+
+		member := providers.Member{
+			Id:             ip.String(),
+			PrivateAddress: ip.String(),
+		}
+		if names, err := resolveAddr(member.PrivateAddress); err == nil && len(names) > 0 {
+			member.Id = strings.TrimSuffix(names[0], ".")
+			member.PrivateDNSName = member.Id
+		}
+
+		members[member.Id] = member
+	}
+
+	return members, nil
+}
+
+func lookupIPv4(host string) (string, error) {
+	ips, err := resolveIP(host)
+	if err != nil {
+		return "", err
+	}
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no IPv4 address found for %s", host)
+}
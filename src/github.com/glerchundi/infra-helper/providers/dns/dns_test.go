@@ -0,0 +1,162 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package dns
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/glerchundi/infra-helper/providers"
+)
+
+// stubResolver answers lookups from the given records and makes the given
+// address local, until the returned function is called.
+func stubResolver(srv map[string][]string, ips map[string][]string, names map[string]string, local string) func() {
+	resolveSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		targets, ok := srv[service+"."+name]
+		if !ok {
+			return "", nil, fmt.Errorf("no such host _%s._%s.%s", service, proto, name)
+		}
+		addrs := make([]*net.SRV, 0)
+		for _, target := range targets {
+			addrs = append(addrs, &net.SRV{Target: target, Port: 2380})
+		}
+		return "", addrs, nil
+	}
+	resolveIP = func(host string) ([]net.IP, error) {
+		addresses, ok := ips[host]
+		if !ok {
+			return nil, fmt.Errorf("no such host %s", host)
+		}
+		result := make([]net.IP, 0)
+		for _, address := range addresses {
+			result = append(result, net.ParseIP(address))
+		}
+		return result, nil
+	}
+	resolveAddr = func(address string) ([]string, error) {
+		name, ok := names[address]
+		if !ok {
+			return nil, fmt.Errorf("no such host %s", address)
+		}
+		return []string{name}, nil
+	}
+	interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP(local), Mask: net.CIDRMask(24, 32)},
+		}, nil
+	}
+
+	return func() {
+		resolveSRV = net.LookupSRV
+		resolveIP = net.LookupIP
+		resolveAddr = net.LookupAddr
+		interfaceAddrs = net.InterfaceAddrs
+	}
+}
+
+var testIPs = map[string][]string{
+	"n1.example.com.":  {"fd00::1", "10.0.0.1"},
+	"n2.example.com.":  {"10.0.0.2"},
+	"n3.example.com.":  {"10.0.0.3"},
+	"etcd.example.com": {"10.0.0.1", "10.0.0.2", "fd00::3", "10.0.0.3"},
+}
+
+func TestLookupSRV(t *testing.T) {
+	// n2 is published for both schemes
+	defer stubResolver(map[string][]string{
+		"etcd-server-ssl.example.com": {"n1.example.com.", "n2.example.com."},
+		"etcd-server.example.com":     {"n2.example.com.", "n3.example.com."},
+	}, testIPs, nil, "10.0.0.2")()
+
+	dns, err := New("example.com", RecordTypeSRV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := dns.GetClusterMembers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]providers.Member{
+		"n1.example.com": {Id: "n1.example.com", PrivateAddress: "10.0.0.1", PrivateDNSName: "n1.example.com"},
+		"n2.example.com": {Id: "n2.example.com", PrivateAddress: "10.0.0.2", PrivateDNSName: "n2.example.com"},
+		"n3.example.com": {Id: "n3.example.com", PrivateAddress: "10.0.0.3", PrivateDNSName: "n3.example.com"},
+	}
+	if !reflect.DeepEqual(members, expected) {
+		t.Errorf("unexpected members: %v", members)
+	}
+
+	if id, err := dns.GetInstanceId(); err != nil || id != "n2.example.com" {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+	if ip, err := dns.GetInstancePrivateAddress(); err != nil || ip != "10.0.0.2" {
+		t.Errorf("GetInstancePrivateAddress() = %q, %v", ip, err)
+	}
+
+	// either service is enough, but not none
+	if members, err := dns.GetClusterMembersByName("missing.com"); err == nil {
+		t.Errorf("expected an error without records, got %v", members)
+	}
+}
+
+func TestLookupA(t *testing.T) {
+	// 10.0.0.3 has no reverse name
+	defer stubResolver(nil, testIPs, map[string]string{
+		"10.0.0.1": "n1.example.com.",
+		"10.0.0.2": "N2.Example.com.",
+	}, "10.0.0.3")()
+
+	dns, err := New("etcd.example.com", RecordTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := dns.GetClusterMembers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]providers.Member{
+		"n1.example.com": {Id: "n1.example.com", PrivateAddress: "10.0.0.1", PrivateDNSName: "n1.example.com"},
+		"N2.Example.com": {Id: "N2.Example.com", PrivateAddress: "10.0.0.2", PrivateDNSName: "N2.Example.com"},
+		"10.0.0.3":       {Id: "10.0.0.3", PrivateAddress: "10.0.0.3"},
+	}
+	if !reflect.DeepEqual(members, expected) {
+		t.Errorf("unexpected members: %v", members)
+	}
+
+	if id, err := dns.GetInstanceId(); err != nil || id != "10.0.0.3" {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+}
+
+func TestFindSelf(t *testing.T) {
+	defer stubResolver(nil, testIPs, nil, "10.0.0.9")()
+
+	dns, err := New("etcd.example.com", RecordTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := dns.GetInstanceId(); err == nil {
+		t.Errorf("expected an error for an instance without records, got %q", id)
+	}
+
+	if _, err := New("etcd.example.com", "mx"); err == nil {
+		t.Errorf("expected an error for an unknown record type")
+	}
+
+	dns, err = New("", RecordTypeSRV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dns.GetClusterMembers(); err == nil {
+		t.Errorf("expected an error without a dns name")
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package gce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+const (
+	DefaultMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+	DefaultApiURL      = "https://www.googleapis.com/compute/v1"
+)
+
+func init() {
+	providers.Register(
+		"gce",
+		[]cli.Flag{
+			cli.StringFlag{
+				Name: "gce-metadata-url",
+				Value: DefaultMetadataURL,
+				Usage: "GCE metadata server base url",
+				EnvVar: "INFRA_HELPER_GCE_METADATA_URL",
+			},
+			cli.StringFlag{
+				Name: "gce-api-url",
+				Value: DefaultApiURL,
+				Usage: "GCE compute api base url",
+				EnvVar: "INFRA_HELPER_GCE_API_URL",
+			},
+		},
+		func(c *cli.Context) (providers.Provider, error) {
+			return New(c.GlobalString("gce-metadata-url"), c.GlobalString("gce-api-url")), nil
+		},
+	)
+}
+
+func New(metadataURL, apiURL string) *Gce {
+	return &Gce{
+		metadataURL: strings.TrimRight(metadataURL, "/"),
+		apiURL:      strings.TrimRight(apiURL, "/"),
+	}
+}
+
+type Gce struct {
+	metadataURL string
+	apiURL      string
+}
+
+type managedInstance struct {
+	Id            string `json:"id"`
+	Instance      string `json:"instance"`
+	CurrentAction string `json:"currentAction"`
+}
+
+type instance struct {
+	Id                string            `json:"id"`
+	Name              string            `json:"name"`
+	Hostname          string            `json:"hostname"`
+	Zone              string            `json:"zone"`
+	CreationTimestamp string            `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels"`
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		Ipv6Address   string `json:"ipv6Address"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+func (gce *Gce) GetInstanceId() (string, error) {
+	return gce.getMetadata("instance/id")
+}
+
+func (gce *Gce) GetInstancePrivateAddress() (string, error) {
+	return gce.getMetadata("instance/network-interfaces/0/ip")
+}
+
+func (gce *Gce) GetClusterMembers() (map[string]providers.Member, error) {
+	// created-by looks like projects/<number>/zones/<zone>/instanceGroupManagers/<name>
+	// or projects/<number>/regions/<region>/instanceGroupManagers/<name>
+	createdBy, err := gce.getMetadata("instance/attributes/created-by")
+	if err != nil {
+		return nil, errors.New("failed to get the managed instance group name")
+	}
+
+	segments := strings.Split(createdBy, "/")
+	if len(segments) != 6 || segments[4] != "instanceGroupManagers" || (segments[2] != "zones" && segments[2] != "regions") {
+		return nil, fmt.Errorf("instance not created by a managed instance group: %s", createdBy)
+	}
+
+	return gce.getClusterMembers(segments[2]+"/"+segments[3], segments[5])
+}
+
+// GetClusterMembersByName returns the members of the named managed instance
+// group, either zonal in the zone of this instance or given by its scope as
+// zones/<zone>/<name> or regions/<region>/<name>.
+func (gce *Gce) GetClusterMembersByName(name string) (map[string]providers.Member, error) {
+	segments := strings.Split(name, "/")
+	switch {
+	case len(segments) == 3 && (segments[0] == "zones" || segments[0] == "regions"):
+		return gce.getClusterMembers(segments[0]+"/"+segments[1], segments[2])
+	case len(segments) != 1:
+		return nil, fmt.Errorf("invalid managed instance group name: %s", name)
+	}
+
+	// zone looks like projects/<number>/zones/<zone>
+	zone, err := gce.getMetadata("instance/zone")
+	if err != nil {
+		return nil, err
+	}
+
+	return gce.getClusterMembers("zones/"+lastSegment(zone), name)
+}
+
+// getClusterMembers returns the instances of the managed instance group with
+// the given name and scope (zones/<zone> or regions/<region>).
+func (gce *Gce) getClusterMembers(scope, name string) (map[string]providers.Member, error) {
+	project, err := gce.getMetadata("project/project-id")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := gce.getAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Managed instances
+	managedInstances, err := gce.listManagedInstances(project, scope, name, token)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]providers.Member)
+	for _, managedInstance := range managedInstances {
+		// instances being created may not exist yet
+		if managedInstance.Instance == "" || managedInstance.CurrentAction == "CREATING" {
+			continue
+		}
+
+		// Instance properties
+		instance, err := gce.getInstance(managedInstance.Instance, token)
+		if err != nil {
+			return nil, err
+		}
+		if len(instance.NetworkInterfaces) == 0 {
+			continue
+		}
+
+		members[instance.Id] = newMember(instance, project, lastSegment(instance.Zone))
+	}
+
+	return members, nil
+}
+
+// newMember returns the cluster member backed by the given instance, its
+// private dns name is the zonal one unless a custom hostname was set.
+func newMember(instance instance, project, zone string) providers.Member {
+	networkInterface := instance.NetworkInterfaces[0]
+
+	member := providers.Member{
+		Id:             instance.Id,
+		PrivateAddress: networkInterface.NetworkIP,
+		IPv6Address:    networkInterface.Ipv6Address,
+		PrivateDNSName: instance.Hostname,
+		Zone:           zone,
+		Tags:           make(map[string]string),
+	}
+
+	if member.PrivateDNSName == "" {
+		member.PrivateDNSName = fmt.Sprintf("%s.%s.c.%s.internal", instance.Name, zone, project)
+	}
+
+	if len(networkInterface.AccessConfigs) > 0 {
+		member.PublicAddress = networkInterface.AccessConfigs[0].NatIP
+	}
+
+	if launchTime, err := time.Parse(time.RFC3339, instance.CreationTimestamp); err == nil {
+		member.LaunchTime = launchTime
+	}
+
+	// labels are the closest thing to tags, network tags are just values
+	for key, value := range instance.Labels {
+		member.Tags[key] = value
+	}
+
+	return member
+}
+
+func (gce *Gce) getMetadata(path string) (string, error) {
+	return util.HttpRequest("GET", gce.metadataURL+"/"+path, map[string]string{
+		"Metadata-Flavor": "Google",
+	})
+}
+
+func (gce *Gce) getAccessToken() (string, error) {
+	out, err := gce.getMetadata("instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal([]byte(out), &token); err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
+
+func (gce *Gce) callApi(method, path, token string, v interface{}) error {
+	out, err := util.HttpRequest(method, gce.apiURL+path, map[string]string{
+		"Authorization": "Bearer " + token,
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(out), v)
+}
+
+func (gce *Gce) listManagedInstances(project, scope, name, token string) ([]managedInstance, error) {
+	var out struct {
+		ManagedInstances []managedInstance `json:"managedInstances"`
+	}
+
+	path := fmt.Sprintf("/projects/%s/%s/instanceGroupManagers/%s/listManagedInstances", project, scope, name)
+	if err := gce.callApi("POST", path, token, &out); err != nil {
+		return nil, err
+	}
+
+	return out.ManagedInstances, nil
+}
+
+// getInstance retrieves an instance given its url, which is resolved against
+// the configured api url.
+func (gce *Gce) getInstance(instanceURL, token string) (instance, error) {
+	index := strings.Index(instanceURL, "/projects/")
+	if index < 0 {
+		return instance{}, fmt.Errorf("invalid instance url: %s", instanceURL)
+	}
+
+	var out instance
+	if err := gce.callApi("GET", instanceURL[index:], token, &out); err != nil {
+		return instance{}, err
+	}
+
+	return out, nil
+}
+
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package gce
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newFakeGce serves the metadata server under /metadata and the compute api
+// under /compute, the instance was created by the given group.
+func newFakeGce(t *testing.T, createdBy string) (*httptest.Server, *Gce) {
+	metadata := map[string]string{
+		"/metadata/instance/id":                             "1",
+		"/metadata/instance/network-interfaces/0/ip":        "10.0.0.1",
+		"/metadata/instance/zone":                           "projects/123/zones/europe-west1-b",
+		"/metadata/instance/attributes/created-by":          createdBy,
+		"/metadata/project/project-id":                      "project",
+		"/metadata/instance/service-accounts/default/token": `{"access_token":"token"}`,
+	}
+
+	instances := map[string]string{
+		"/compute/projects/project/zones/europe-west1-b/instances/etcd-1": `{
+			"id": "1", "name": "etcd-1", "zone": "https://www.googleapis.com/compute/v1/projects/project/zones/europe-west1-b",
+			"creationTimestamp": "2015-10-01T10:00:00.000-07:00", "labels": {"role": "etcd"},
+			"networkInterfaces": [{"networkIP": "10.0.0.1", "accessConfigs": [{"natIP": "1.2.3.4"}]}]
+		}`,
+		"/compute/projects/project/zones/europe-west1-c/instances/etcd-2": `{
+			"id": "2", "name": "etcd-2", "hostname": "etcd-2.example.com", "zone": "https://www.googleapis.com/compute/v1/projects/project/zones/europe-west1-c",
+			"networkInterfaces": [{"networkIP": "10.0.0.2", "ipv6Address": "2001:db8::2"}]
+		}`,
+	}
+
+	managedInstances := `{"managedInstances": [
+		{"id": "1", "instance": "https://www.googleapis.com/compute/v1/projects/project/zones/europe-west1-b/instances/etcd-1", "currentAction": "NONE"},
+		{"id": "2", "instance": "https://www.googleapis.com/compute/v1/projects/project/zones/europe-west1-c/instances/etcd-2", "currentAction": "NONE"},
+		{"id": "3", "instance": "https://www.googleapis.com/compute/v1/projects/project/zones/europe-west1-d/instances/etcd-3", "currentAction": "CREATING"}
+	]}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if out, ok := metadata[r.URL.Path]; ok {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, out)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/compute/projects/project/regions/europe-west1/instanceGroupManagers/etcd/listManagedInstances":
+			fmt.Fprint(w, managedInstances)
+		case r.Method == "POST" && r.URL.Path == "/compute/projects/project/zones/europe-west1-b/instanceGroupManagers/etcd/listManagedInstances":
+			fmt.Fprint(w, managedInstances)
+		case r.Method == "GET" && instances[r.URL.Path] != "":
+			fmt.Fprint(w, instances[r.URL.Path])
+		default:
+			t.Logf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, New(server.URL+"/metadata", server.URL+"/compute")
+}
+
+func TestGetInstance(t *testing.T) {
+	server, gce := newFakeGce(t, "projects/123/zones/europe-west1-b/instanceGroupManagers/etcd")
+	defer server.Close()
+
+	if id, err := gce.GetInstanceId(); err != nil || id != "1" {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+	if ip, err := gce.GetInstancePrivateAddress(); err != nil || ip != "10.0.0.1" {
+		t.Errorf("GetInstancePrivateAddress() = %q, %v", ip, err)
+	}
+}
+
+func TestGetClusterMembers(t *testing.T) {
+	for _, createdBy := range []string{
+		"projects/123/zones/europe-west1-b/instanceGroupManagers/etcd",
+		"projects/123/regions/europe-west1/instanceGroupManagers/etcd",
+	} {
+		server, gce := newFakeGce(t, createdBy)
+
+		members, err := gce.GetClusterMembers()
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", createdBy, err)
+			continue
+		}
+
+		if len(members) != 2 {
+			t.Errorf("%s: expected 2 members, got %v", createdBy, members)
+			continue
+		}
+
+		first := members["1"]
+		if first.PrivateAddress != "10.0.0.1" || first.PublicAddress != "1.2.3.4" || first.Zone != "europe-west1-b" ||
+			first.PrivateDNSName != "etcd-1.europe-west1-b.c.project.internal" || first.Tags["role"] != "etcd" ||
+			!first.LaunchTime.Equal(time.Date(2015, 10, 1, 17, 0, 0, 0, time.UTC)) {
+			t.Errorf("%s: unexpected member: %+v", createdBy, first)
+		}
+
+		second := members["2"]
+		if second.PrivateAddress != "10.0.0.2" || second.IPv6Address != "2001:db8::2" || second.Zone != "europe-west1-c" ||
+			second.PrivateDNSName != "etcd-2.example.com" {
+			t.Errorf("%s: unexpected member: %+v", createdBy, second)
+		}
+	}
+}
+
+func TestGetClusterMembersByName(t *testing.T) {
+	server, gce := newFakeGce(t, "")
+	defer server.Close()
+
+	for _, name := range []string{"etcd", "regions/europe-west1/etcd", "zones/europe-west1-b/etcd"} {
+		members, err := gce.GetClusterMembersByName(name)
+		if err != nil || len(members) != 2 {
+			t.Errorf("%s: unexpected members %v, %v", name, members, err)
+		}
+	}
+
+	if _, err := gce.GetClusterMembersByName("zones/etcd"); err == nil {
+		t.Errorf("expected an error for an invalid name")
+	}
+}
+
+func TestGetClusterMembersNotManaged(t *testing.T) {
+	server, gce := newFakeGce(t, "projects/123/zones/europe-west1-b/instanceGroups/etcd")
+	defer server.Close()
+
+	if _, err := gce.GetClusterMembers(); err == nil {
+		t.Errorf("expected an error for an instance not created by a managed instance group")
+	}
+}
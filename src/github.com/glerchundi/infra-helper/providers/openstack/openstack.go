@@ -0,0 +1,458 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package openstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/glerchundi/infra-helper/util"
+)
+
+const (
+	DefaultMetadataURL = "http://169.254.169.254"
+	DefaultMetadataKey = "etcd-cluster"
+
+	DiscoveryMetadata    = "metadata"
+	DiscoveryServerGroup = "server-group"
+)
+
+func init() {
+	providers.Register(
+		"openstack",
+		[]cli.Flag{
+			cli.StringFlag{
+				Name: "openstack-metadata-url",
+				Value: DefaultMetadataURL,
+				Usage: "OpenStack metadata service base url",
+				EnvVar: "INFRA_HELPER_OPENSTACK_METADATA_URL",
+			},
+			cli.StringFlag{
+				Name: "openstack-auth-url",
+				Usage: "OpenStack identity (keystone v3) url",
+				EnvVar: "INFRA_HELPER_OPENSTACK_AUTH_URL,OS_AUTH_URL",
+			},
+			cli.StringFlag{
+				Name: "openstack-username",
+				Usage: "OpenStack username",
+				EnvVar: "INFRA_HELPER_OPENSTACK_USERNAME,OS_USERNAME",
+			},
+			cli.StringFlag{
+				Name: "openstack-password",
+				Usage: "OpenStack password",
+				EnvVar: "INFRA_HELPER_OPENSTACK_PASSWORD,OS_PASSWORD",
+			},
+			cli.StringFlag{
+				Name: "openstack-user-domain-name",
+				Value: "Default",
+				Usage: "OpenStack user domain name",
+				EnvVar: "INFRA_HELPER_OPENSTACK_USER_DOMAIN_NAME,OS_USER_DOMAIN_NAME",
+			},
+			cli.StringFlag{
+				Name: "openstack-project-name",
+				Usage: "OpenStack project name",
+				EnvVar: "INFRA_HELPER_OPENSTACK_PROJECT_NAME,OS_PROJECT_NAME",
+			},
+			cli.StringFlag{
+				Name: "openstack-project-domain-name",
+				Value: "Default",
+				Usage: "OpenStack project domain name",
+				EnvVar: "INFRA_HELPER_OPENSTACK_PROJECT_DOMAIN_NAME,OS_PROJECT_DOMAIN_NAME",
+			},
+			cli.StringFlag{
+				Name: "openstack-region",
+				Usage: "OpenStack region used to pick the compute endpoint from the catalog",
+				EnvVar: "INFRA_HELPER_OPENSTACK_REGION,OS_REGION_NAME",
+			},
+			cli.StringFlag{
+				Name: "openstack-compute-url",
+				Usage: "OpenStack compute (nova) url, overrides the one in the catalog",
+				EnvVar: "INFRA_HELPER_OPENSTACK_COMPUTE_URL",
+			},
+			cli.StringFlag{
+				Name: "openstack-discovery",
+				Value: DiscoveryMetadata,
+				Usage: "how peers are discovered (metadata, server-group)",
+				EnvVar: "INFRA_HELPER_OPENSTACK_DISCOVERY",
+			},
+			cli.StringFlag{
+				Name: "openstack-metadata-key",
+				Value: DefaultMetadataKey,
+				Usage: "server metadata key whose value names the cluster",
+				EnvVar: "INFRA_HELPER_OPENSTACK_METADATA_KEY",
+			},
+			cli.StringFlag{
+				Name: "openstack-server-group",
+				Usage: "server group (id or name) whose members form the cluster",
+				EnvVar: "INFRA_HELPER_OPENSTACK_SERVER_GROUP",
+			},
+		},
+		func(c *cli.Context) (providers.Provider, error) {
+			discovery := c.GlobalString("openstack-discovery")
+			if discovery != DiscoveryMetadata && discovery != DiscoveryServerGroup {
+				return nil, fmt.Errorf("unknown openstack discovery: %s", discovery)
+			}
+
+			return &OpenStack{
+				metadataURL:       strings.TrimRight(c.GlobalString("openstack-metadata-url"), "/"),
+				authURL:           strings.TrimRight(c.GlobalString("openstack-auth-url"), "/"),
+				username:          c.GlobalString("openstack-username"),
+				password:          c.GlobalString("openstack-password"),
+				userDomainName:    c.GlobalString("openstack-user-domain-name"),
+				projectName:       c.GlobalString("openstack-project-name"),
+				projectDomainName: c.GlobalString("openstack-project-domain-name"),
+				region:            c.GlobalString("openstack-region"),
+				computeURL:        strings.TrimRight(c.GlobalString("openstack-compute-url"), "/"),
+				discovery:         discovery,
+				metadataKey:       c.GlobalString("openstack-metadata-key"),
+				serverGroup:       c.GlobalString("openstack-server-group"),
+			}, nil
+		},
+	)
+}
+
+type OpenStack struct {
+	metadataURL       string
+	authURL           string
+	username          string
+	password          string
+	userDomainName    string
+	projectName       string
+	projectDomainName string
+	region            string
+	computeURL        string
+	discovery         string
+	metadataKey       string
+	serverGroup       string
+}
+
+type instanceMetadata struct {
+	Uuid string            `json:"uuid"`
+	Meta map[string]string `json:"meta"`
+}
+
+type server struct {
+	Id               string            `json:"id"`
+	Name             string            `json:"name"`
+	Created          string            `json:"created"`
+	AvailabilityZone string            `json:"OS-EXT-AZ:availability_zone"`
+	Metadata         map[string]string `json:"metadata"`
+	Addresses map[string][]struct {
+		Addr    string `json:"addr"`
+		Version int    `json:"version"`
+		Type    string `json:"OS-EXT-IPS:type"`
+	} `json:"addresses"`
+}
+
+type serverGroup struct {
+	Id      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// session holds a keystone token and the compute endpoint it grants access to.
+type session struct {
+	token      string
+	computeURL string
+}
+
+func (openstack *OpenStack) GetInstanceId() (string, error) {
+	metadata, err := openstack.getInstanceMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	return metadata.Uuid, nil
+}
+
+func (openstack *OpenStack) GetInstancePrivateAddress() (string, error) {
+	// EC2 compatible metadata, also served by nova
+	return util.HttpRequest("GET", openstack.metadataURL+"/latest/meta-data/local-ipv4", nil)
+}
+
+func (openstack *OpenStack) GetClusterMembers() (map[string]providers.Member, error) {
+	if openstack.discovery == DiscoveryServerGroup {
+		if openstack.serverGroup == "" {
+			return nil, errors.New("openstack server group not specified")
+		}
+		return openstack.GetClusterMembersByName(openstack.serverGroup)
+	}
+
+	metadata, err := openstack.getInstanceMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	name, ok := metadata.Meta[openstack.metadataKey]
+	if !ok {
+		return nil, fmt.Errorf("instance has no %s metadata key", openstack.metadataKey)
+	}
+
+	return openstack.GetClusterMembersByName(name)
+}
+
+func (openstack *OpenStack) GetClusterMembersByName(name string) (map[string]providers.Member, error) {
+	s, err := openstack.authenticate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Which servers belong to the cluster
+	var belongs func(*server) bool
+	if openstack.discovery == DiscoveryServerGroup {
+		group, err := s.findServerGroup(name)
+		if err != nil {
+			return nil, err
+		}
+
+		isMember := make(map[string]bool)
+		for _, id := range group.Members {
+			isMember[id] = true
+		}
+		belongs = func(srv *server) bool {
+			return isMember[srv.Id]
+		}
+	} else {
+		belongs = func(srv *server) bool {
+			return srv.Metadata[openstack.metadataKey] == name
+		}
+	}
+
+	servers, err := s.listServers()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]providers.Member)
+	for _, srv := range servers {
+		if !belongs(&srv) {
+			continue
+		}
+		if address := serverAddress(&srv, 4, "fixed"); address != "" {
+			members[srv.Id] = newMember(&srv, address)
+		}
+	}
+
+	return members, nil
+}
+
+// newMember returns the cluster member backed by the given server, metadata
+// is exposed as tags.
+func newMember(srv *server, privateAddress string) providers.Member {
+	member := providers.Member{
+		Id:             srv.Id,
+		PrivateAddress: privateAddress,
+		PublicAddress:  serverAddress(srv, 4, "floating"),
+		IPv6Address:    serverAddress(srv, 6, "fixed"),
+		PrivateDNSName: srv.Name,
+		Zone:           srv.AvailabilityZone,
+		Tags:           make(map[string]string),
+	}
+
+	if launchTime, err := time.Parse(time.RFC3339, srv.Created); err == nil {
+		member.LaunchTime = launchTime
+	}
+
+	for key, value := range srv.Metadata {
+		member.Tags[key] = value
+	}
+
+	return member
+}
+
+func (openstack *OpenStack) getInstanceMetadata() (*instanceMetadata, error) {
+	out, err := util.HttpRequest("GET", openstack.metadataURL+"/openstack/latest/meta_data.json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &instanceMetadata{}
+	if err := json.Unmarshal([]byte(out), metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func (openstack *OpenStack) authenticate() (*session, error) {
+	if openstack.authURL == "" {
+		return nil, errors.New("openstack auth url not specified")
+	}
+
+	var request struct {
+		Auth struct {
+			Identity struct {
+				Methods  []string `json:"methods"`
+				Password struct {
+					User struct {
+						Name     string `json:"name"`
+						Password string `json:"password"`
+						Domain   struct {
+							Name string `json:"name"`
+						} `json:"domain"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+			Scope struct {
+				Project struct {
+					Name   string `json:"name"`
+					Domain struct {
+						Name string `json:"name"`
+					} `json:"domain"`
+				} `json:"project"`
+			} `json:"scope"`
+		} `json:"auth"`
+	}
+
+	request.Auth.Identity.Methods = []string{"password"}
+	request.Auth.Identity.Password.User.Name = openstack.username
+	request.Auth.Identity.Password.User.Password = openstack.password
+	request.Auth.Identity.Password.User.Domain.Name = openstack.userDomainName
+	request.Auth.Scope.Project.Name = openstack.projectName
+	request.Auth.Scope.Project.Domain.Name = openstack.projectDomainName
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	out, header, err := util.HttpPost(openstack.authURL+"/auth/tokens", map[string]string{
+		"Content-Type": "application/json",
+	}, string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Token struct {
+			Catalog []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					Url       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(out), &response); err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		token:      header.Get("X-Subject-Token"),
+		computeURL: openstack.computeURL,
+	}
+
+	// Compute endpoint, prefer internal over public ones
+	if s.computeURL == "" {
+	catalog:
+		for _, iface := range []string{"internal", "public"} {
+			for _, service := range response.Token.Catalog {
+				if service.Type != "compute" {
+					continue
+				}
+				for _, endpoint := range service.Endpoints {
+					if endpoint.Interface == iface && (openstack.region == "" || endpoint.Region == openstack.region) {
+						s.computeURL = strings.TrimRight(endpoint.Url, "/")
+						break catalog
+					}
+				}
+			}
+		}
+	}
+
+	if s.computeURL == "" {
+		return nil, errors.New("failed to get the compute endpoint")
+	}
+
+	return s, nil
+}
+
+func (s *session) get(url string, v interface{}) error {
+	out, err := util.HttpRequest("GET", url, map[string]string{
+		"X-Auth-Token": s.token,
+		"Accept":       "application/json",
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(out), v)
+}
+
+func (s *session) findServerGroup(idOrName string) (*serverGroup, error) {
+	var out struct {
+		ServerGroups []serverGroup `json:"server_groups"`
+	}
+	if err := s.get(s.computeURL+"/os-server-groups", &out); err != nil {
+		return nil, err
+	}
+
+	for _, group := range out.ServerGroups {
+		if group.Id == idOrName || group.Name == idOrName {
+			return &group, nil
+		}
+	}
+
+	return nil, errors.New("failed to get the server group")
+}
+
+func (s *session) listServers() ([]server, error) {
+	servers := make([]server, 0)
+
+	url := s.computeURL + "/servers/detail"
+	for url != "" {
+		var out struct {
+			Servers      []server `json:"servers"`
+			ServersLinks []struct {
+				Href string `json:"href"`
+				Rel  string `json:"rel"`
+			} `json:"servers_links"`
+		}
+		if err := s.get(url, &out); err != nil {
+			return nil, err
+		}
+
+		servers = append(servers, out.Servers...)
+
+		url = ""
+		for _, link := range out.ServersLinks {
+			if link.Rel == "next" {
+				url = link.Href
+			}
+		}
+	}
+
+	return servers, nil
+}
+
+// serverAddress returns the first address of the given ip version and type
+// (fixed or floating) of the server, networks are sorted by name to make it
+// deterministic. Addresses without type are taken as fixed.
+func serverAddress(srv *server, version int, addressType string) string {
+	networks := make([]string, 0)
+	for network := range srv.Addresses {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+
+	for _, network := range networks {
+		for _, address := range srv.Addresses[network] {
+			if address.Version != version {
+				continue
+			}
+			if address.Type == addressType || (address.Type == "" && addressType == "fixed") {
+				return address.Addr
+			}
+		}
+	}
+	return ""
+}
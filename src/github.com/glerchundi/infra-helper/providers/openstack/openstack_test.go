@@ -0,0 +1,163 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newFakeOpenStack serves the metadata service under /metadata, keystone
+// under /identity and nova under /compute. The catalog lists later compute
+// endpoints which must not be picked.
+func newFakeOpenStack(t *testing.T, discovery string) (*httptest.Server, *OpenStack) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/openstack/latest/meta_data.json":
+			fmt.Fprint(w, `{"uuid": "s1", "meta": {"etcd-cluster": "prod"}}`)
+			return
+		case "/metadata/latest/meta-data/local-ipv4":
+			fmt.Fprint(w, "10.0.0.1")
+			return
+		case "/identity/auth/tokens":
+			var request struct {
+				Auth struct {
+					Identity struct {
+						Password struct {
+							User struct {
+								Name     string `json:"name"`
+								Password string `json:"password"`
+							} `json:"user"`
+						} `json:"password"`
+					} `json:"identity"`
+				} `json:"auth"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Auth.Identity.Password.User.Password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("X-Subject-Token", "token")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": {"catalog": [
+				{"type": "identity", "endpoints": [{"interface": "internal", "region": "one", "url": "%[1]s/identity"}]},
+				{"type": "compute", "endpoints": [
+					{"interface": "public", "region": "one", "url": "%[1]s/public"},
+					{"interface": "internal", "region": "two", "url": "%[1]s/other-region"},
+					{"interface": "internal", "region": "one", "url": "%[1]s/compute/"}
+				]},
+				{"type": "compute", "endpoints": [{"interface": "internal", "region": "one", "url": "%[1]s/later"}]}
+			]}}`, server.URL)
+			return
+		}
+
+		if r.Header.Get("X-Auth-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/compute/os-server-groups":
+			fmt.Fprint(w, `{"server_groups": [{"id": "g1", "name": "etcd", "members": ["s1", "s3"]}]}`)
+		case r.URL.Path == "/compute/servers/detail" && r.URL.Query().Get("marker") == "":
+			fmt.Fprintf(w, `{"servers": [
+				{"id": "s1", "name": "etcd-1", "created": "2015-10-01T10:00:00Z", "OS-EXT-AZ:availability_zone": "nova",
+				 "metadata": {"etcd-cluster": "prod"},
+				 "addresses": {"private": [
+					{"addr": "10.0.0.1", "version": 4, "OS-EXT-IPS:type": "fixed"},
+					{"addr": "fd00::1", "version": 6, "OS-EXT-IPS:type": "fixed"},
+					{"addr": "1.2.3.4", "version": 4, "OS-EXT-IPS:type": "floating"}
+				 ]}},
+				{"id": "s2", "name": "web-1", "metadata": {"etcd-cluster": "dev"},
+				 "addresses": {"private": [{"addr": "10.0.0.2", "version": 4}]}}
+			], "servers_links": [{"rel": "next", "href": "%s/compute/servers/detail?marker=s2"}]}`, server.URL)
+		case r.URL.Path == "/compute/servers/detail":
+			fmt.Fprint(w, `{"servers": [
+				{"id": "s3", "name": "etcd-3", "metadata": {"etcd-cluster": "prod"},
+				 "addresses": {"private": [{"addr": "10.0.0.3", "version": 4}]}}
+			]}`)
+		default:
+			t.Logf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, &OpenStack{
+		metadataURL:       server.URL + "/metadata",
+		authURL:           server.URL + "/identity",
+		username:          "user",
+		password:          "secret",
+		userDomainName:    "Default",
+		projectName:       "project",
+		projectDomainName: "Default",
+		region:            "one",
+		discovery:         discovery,
+		metadataKey:       DefaultMetadataKey,
+		serverGroup:       "etcd",
+	}
+}
+
+func TestGetInstance(t *testing.T) {
+	server, openstack := newFakeOpenStack(t, DiscoveryMetadata)
+	defer server.Close()
+
+	if id, err := openstack.GetInstanceId(); err != nil || id != "s1" {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+	if ip, err := openstack.GetInstancePrivateAddress(); err != nil || ip != "10.0.0.1" {
+		t.Errorf("GetInstancePrivateAddress() = %q, %v", ip, err)
+	}
+}
+
+func TestGetClusterMembers(t *testing.T) {
+	for _, discovery := range []string{DiscoveryMetadata, DiscoveryServerGroup} {
+		server, openstack := newFakeOpenStack(t, discovery)
+
+		members, err := openstack.GetClusterMembers()
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", discovery, err)
+			continue
+		}
+
+		if len(members) != 2 || members["s3"].PrivateAddress != "10.0.0.3" {
+			t.Errorf("%s: unexpected members: %v", discovery, members)
+			continue
+		}
+
+		first := members["s1"]
+		if first.PrivateAddress != "10.0.0.1" || first.PublicAddress != "1.2.3.4" || first.IPv6Address != "fd00::1" ||
+			first.PrivateDNSName != "etcd-1" || first.Zone != "nova" || first.Tags["etcd-cluster"] != "prod" ||
+			!first.LaunchTime.Equal(time.Date(2015, 10, 1, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("%s: unexpected member: %+v", discovery, first)
+		}
+	}
+}
+
+func TestAuthenticateComputeEndpoint(t *testing.T) {
+	server, openstack := newFakeOpenStack(t, DiscoveryMetadata)
+	defer server.Close()
+
+	s, err := openstack.authenticate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.computeURL != server.URL+"/compute" {
+		t.Errorf("expected the first internal compute endpoint of the region, got %s", s.computeURL)
+	}
+
+	openstack.region = "three"
+	if _, err := openstack.authenticate(); err == nil {
+		t.Errorf("expected an error for a region without compute endpoints")
+	}
+
+	openstack.computeURL = "http://compute.example.com"
+	if s, err := openstack.authenticate(); err != nil || s.computeURL != "http://compute.example.com" {
+		t.Errorf("expected the configured compute url, got %v, %v", s, err)
+	}
+}
@@ -3,9 +3,96 @@
 // that can be found in the LICENSE file.
 package providers
 
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
 type Provider interface {
 	GetInstanceId() (string, error)
 	GetInstancePrivateAddress() (string, error)
-	GetClusterMembers() (map[string]string, error)
-	GetClusterMembersByName(name string) (map[string]string, error)
-}
\ No newline at end of file
+	GetClusterMembers() (map[string]Member, error)
+	GetClusterMembersByName(name string) (map[string]Member, error)
+}
+
+// Member describes a cluster member, indexed by Id in the maps returned by
+// providers. Fields a provider knows nothing about are left empty, states
+// included: members are returned regardless of their lifecycle state.
+type Member struct {
+	Id             string
+	PrivateAddress string
+	PublicAddress  string
+	IPv6Address    string
+	PrivateDNSName string
+	Zone           string
+	LifecycleState string
+	HealthStatus   string
+	LaunchTime     time.Time
+	Tags           map[string]string
+}
+
+// Termination is a cluster member about to be terminated, which is held
+// until the termination is completed. Handle identifies it to the provider.
+type Termination struct {
+	InstanceId string
+	Handle     interface{}
+}
+
+// LifecycleProvider is implemented by providers able to notify member
+// terminations in advance, i.e. through auto scaling lifecycle hooks.
+type LifecycleProvider interface {
+	// ReceiveTerminations waits up to the given time for terminations.
+	ReceiveTerminations(wait time.Duration) ([]Termination, error)
+	// CompleteTermination lets a received termination go on.
+	CompleteTermination(termination Termination) error
+}
+
+// Factory creates a provider configured from the (global) command line flags.
+type Factory func(c *cli.Context) (Provider, error)
+
+type registration struct {
+	flags   []cli.Flag
+	factory Factory
+}
+
+var registry = make(map[string]registration)
+
+// Register makes a provider available by name. Flags are exposed as global
+// options so they should be prefixed with the provider name to avoid clashes.
+func Register(name string, flags []cli.Flag, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("provider %s already registered", name))
+	}
+	registry[name] = registration{flags, factory}
+}
+
+// Names returns the sorted names of the registered providers.
+func Names() []string {
+	names := make([]string, 0)
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Flags returns the flags of every registered provider.
+func Flags() []cli.Flag {
+	flags := make([]cli.Flag, 0)
+	for _, name := range Names() {
+		flags = append(flags, registry[name].flags...)
+	}
+	return flags
+}
+
+// New creates the provider registered with the given name.
+func New(name string, c *cli.Context) (Provider, error) {
+	r, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+	return r.factory(c)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package static
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/glerchundi/infra-helper/providers"
+	"github.com/vaughan0/go-ini"
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultCluster = "default"
+
+func init() {
+	providers.Register(
+		"static",
+		[]cli.Flag{
+			cli.StringFlag{
+				Name: "static-file",
+				Usage: "file (.json, .yaml or .ini) listing instance ids and addresses per cluster",
+				EnvVar: "INFRA_HELPER_STATIC_FILE",
+			},
+			cli.StringFlag{
+				Name: "static-cluster",
+				Value: DefaultCluster,
+				Usage: "cluster of the static file this instance belongs to",
+				EnvVar: "INFRA_HELPER_STATIC_CLUSTER",
+			},
+			cli.StringFlag{
+				Name: "static-self",
+				Usage: "instance id of this instance (defaults to hostname or local address lookup)",
+				EnvVar: "INFRA_HELPER_STATIC_SELF",
+			},
+		},
+		func(c *cli.Context) (providers.Provider, error) {
+			return New(c.GlobalString("static-file"), c.GlobalString("static-cluster"), c.GlobalString("static-self"))
+		},
+	)
+}
+
+// New loads the clusters defined in the given file. JSON files map cluster
+// names to objects of instance id and address pairs:
+//
+//	{"default": {"node1": "10.0.0.1", "node2": "10.0.0.2"}}
+//
+// YAML files follow the same structure:
+//
+//	default:
+//	  node1: 10.0.0.1
+//	  node2: 10.0.0.2
+//
+// INI files use one section per cluster, keys outside of any section belong
+// to the default cluster:
+//
+//	node1=10.0.0.1
+//	node2=10.0.0.2
+func New(file, cluster, self string) (*Static, error) {
+	if file == "" {
+		return nil, errors.New("static file not specified")
+	}
+
+	clusters, err := loadClusters(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Static{
+		clusters: clusters,
+		cluster:  cluster,
+		self:     self,
+	}, nil
+}
+
+type Static struct {
+	clusters map[string]map[string]string
+	cluster  string
+	self     string
+}
+
+func (static *Static) GetInstanceId() (string, error) {
+	if static.self != "" {
+		return static.self, nil
+	}
+
+	members, err := static.GetClusterMembers()
+	if err != nil {
+		return "", err
+	}
+
+	// by hostname
+	if hostname, err := os.Hostname(); err == nil {
+		if _, ok := members[hostname]; ok {
+			return hostname, nil
+		}
+	}
+
+	// by local interface address
+	localAddresses, err := getLocalAddresses()
+	if err != nil {
+		return "", err
+	}
+
+	for memberName, member := range members {
+		if localAddresses[member.PrivateAddress] {
+			return memberName, nil
+		}
+	}
+
+	return "", errors.New("failed to identify this instance in the static file")
+}
+
+func (static *Static) GetInstancePrivateAddress() (string, error) {
+	instanceId, err := static.GetInstanceId()
+	if err != nil {
+		return "", err
+	}
+
+	members, err := static.GetClusterMembers()
+	if err != nil {
+		return "", err
+	}
+
+	member, ok := members[instanceId]
+	if !ok {
+		return "", fmt.Errorf("instance %s not found in the static file", instanceId)
+	}
+
+	return member.PrivateAddress, nil
+}
+
+func (static *Static) GetClusterMembers() (map[string]providers.Member, error) {
+	return static.GetClusterMembersByName(static.cluster)
+}
+
+func (static *Static) GetClusterMembersByName(name string) (map[string]providers.Member, error) {
+	addresses, ok := static.clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("cluster %s not found in the static file", name)
+	}
+
+	members := make(map[string]providers.Member)
+	for memberName, memberIp := range addresses {
+		members[memberName] = providers.Member{
+			Id:             memberName,
+			PrivateAddress: memberIp,
+		}
+	}
+
+	return members, nil
+}
+
+func loadClusters(file string) (map[string]map[string]string, error) {
+	clusters := make(map[string]map[string]string)
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &clusters); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &clusters); err != nil {
+			return nil, err
+		}
+	case ".ini":
+		f, err := ini.LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for name, section := range f {
+			if name == "" {
+				name = DefaultCluster
+			}
+			if clusters[name] == nil {
+				clusters[name] = make(map[string]string)
+			}
+			for memberName, memberIp := range section {
+				clusters[name][memberName] = memberIp
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported static file format: %s", file)
+	}
+
+	return clusters, nil
+}
+
+func getLocalAddresses() (map[string]bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	localAddresses := make(map[string]bool)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			localAddresses[ipNet.IP.String()] = true
+		}
+	}
+
+	return localAddresses, nil
+}
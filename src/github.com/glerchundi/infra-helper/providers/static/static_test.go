@@ -0,0 +1,92 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package static
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadClusters(t *testing.T) {
+	files := map[string]string{
+		"cluster.json": `{"default": {"n1": "10.0.0.1", "n2": "10.0.0.2"}, "other": {"n3": "10.0.0.3"}}`,
+		"cluster.yaml": "default:\n  n1: 10.0.0.1\n  n2: 10.0.0.2\nother:\n  n3: 10.0.0.3\n",
+		"cluster.yml":  "default: {n1: 10.0.0.1, n2: 10.0.0.2}\nother: {n3: 10.0.0.3}\n",
+		"cluster.ini":  "n1=10.0.0.1\nn2=10.0.0.2\n[other]\nn3=10.0.0.3\n",
+	}
+
+	for name, content := range files {
+		static, err := New(writeFile(t, name, content), DefaultCluster, "n1")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+
+		members, err := static.GetClusterMembers()
+		if err != nil || len(members) != 2 || members["n1"].PrivateAddress != "10.0.0.1" || members["n2"].PrivateAddress != "10.0.0.2" {
+			t.Errorf("%s: unexpected members %v, %v", name, members, err)
+		}
+
+		members, err = static.GetClusterMembersByName("other")
+		if err != nil || len(members) != 1 || members["n3"].Id != "n3" {
+			t.Errorf("%s: unexpected members of other %v, %v", name, members, err)
+		}
+
+		if _, err := static.GetClusterMembersByName("missing"); err == nil {
+			t.Errorf("%s: expected an error for a missing cluster", name)
+		}
+	}
+
+	if _, err := New(writeFile(t, "cluster.txt", ""), DefaultCluster, ""); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+}
+
+func TestGetInstanceId(t *testing.T) {
+	file := writeFile(t, "cluster.json", `{"default": {"n1": "10.0.0.1", "n2": "127.0.0.1"}}`)
+
+	// explicit
+	static, err := New(file, DefaultCluster, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := static.GetInstanceId(); err != nil || id != "n1" {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+	if ip, err := static.GetInstancePrivateAddress(); err != nil || ip != "10.0.0.1" {
+		t.Errorf("GetInstancePrivateAddress() = %q, %v", ip, err)
+	}
+
+	// by local interface address
+	static, err = New(file, DefaultCluster, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := static.GetInstanceId(); err != nil || id != "n2" {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+
+	// by hostname
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	static, err = New(writeFile(t, "cluster.yaml", "default:\n  "+hostname+": 10.0.0.9\n  n1: 127.0.0.1\n"), DefaultCluster, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := static.GetInstanceId(); err != nil || id != hostname {
+		t.Errorf("GetInstanceId() = %q, %v", id, err)
+	}
+}
@@ -17,23 +17,44 @@
 package util
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coreos/etcd/client"
 	"github.com/coreos/etcd/pkg/transport"
 	"golang.org/x/net/context"
 )
 
+const (
+	DefaultEtcdPeerPort   = 2380
+	DefaultEtcdClientPort = 2379
+)
+
+// etcdTLSInfo is the TLS material used to connect to etcd client urls, it
+// defaults to the one etcdctl uses.
+var etcdTLSInfo = transport.TLSInfo{
+	CAFile:   os.Getenv("ETCDCTL_CA_FILE"),
+	CertFile: os.Getenv("ETCDCTL_CERT_FILE"),
+	KeyFile:  os.Getenv("ETCDCTL_KEY_FILE"),
+}
+
+// SetEtcdClientTLS sets the TLS material used to connect to etcd client urls.
+func SetEtcdClientTLS(caFile, certFile, keyFile string) {
+	etcdTLSInfo = transport.TLSInfo{
+		CAFile:   caFile,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+}
+
 func getEtcdTransport() (*http.Transport, error) {
-	return transport.NewTransport(
-		transport.TLSInfo{
-			CAFile:   os.Getenv("ETCDCTL_CA_FILE"),
-			CertFile: os.Getenv("ETCDCTL_CERT_FILE"),
-			KeyFile:  os.Getenv("ETCDCTL_KEY_FILE"),
-		},
-	)
+	return transport.NewTransport(etcdTLSInfo)
 }
 
 func newEtcdClient(url string) (client.Client, error) {
@@ -64,12 +85,10 @@ func newEtcdMembersAPI(url string) (client.MembersAPI, error) {
 	return client.NewMembersAPI(hc), nil
 }
 
-func EtcdPeerURLFromIP(ip string) string {
-	return fmt.Sprintf("http://%s:2380", ip)
-}
-
-func EtcdClientURLFromIP(ip string) string {
-	return fmt.Sprintf("http://%s:2379", ip)
+// EtcdURL returns the url of the given ip or hostname, IPv6 addresses are
+// enclosed in brackets.
+func EtcdURL(scheme, host string, port int) string {
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
 }
 
 func EtcdListMembers(url string) (members []client.Member, err error) {
@@ -111,4 +130,79 @@ func EtcdRemoveMember(url, removalID string) (err error) {
 	cancel()
 
 	return
-}
\ No newline at end of file
+}
+
+// EtcdGetKey returns the value of the given key, empty if it doesn't exist.
+func EtcdGetKey(url, key string) (string, error) {
+	hc, err := newEtcdClient(url)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
+	resp, err := client.NewKeysAPI(hc).Get(ctx, key, nil)
+	cancel()
+
+	if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeKeyNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Node.Value, nil
+}
+
+// EtcdCreateKey sets the value of the given key, expiring after the given ttl,
+// unless it already exists.
+func EtcdCreateKey(url, key, value string, ttl time.Duration) error {
+	hc, err := newEtcdClient(url)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
+	_, err = client.NewKeysAPI(hc).Set(ctx, key, value, &client.SetOptions{
+		PrevExist: client.PrevNoExist,
+		TTL:       ttl,
+	})
+	cancel()
+
+	if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeNodeExist {
+		return nil
+	}
+
+	return err
+}
+
+func EtcdIsHealthy(url string) bool {
+	tr, err := getEtcdTransport()
+	if err != nil {
+		return false
+	}
+
+	hc := http.Client{
+		Transport: tr,
+		Timeout:   client.DefaultRequestTimeout,
+	}
+
+	resp, err := hc.Get(strings.TrimRight(url, "/") + "/health")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	// {"health": "true"}
+	var health struct {
+		Health string `json:"health"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return false
+	}
+
+	return health.Health == "true"
+}
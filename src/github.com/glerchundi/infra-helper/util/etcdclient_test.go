@@ -0,0 +1,27 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package util
+
+import "testing"
+
+func TestEtcdURL(t *testing.T) {
+	tests := []struct {
+		scheme string
+		host   string
+		port   int
+		url    string
+	}{
+		{"http", "10.0.0.1", 2380, "http://10.0.0.1:2380"},
+		{"https", "fd00::1", 2379, "https://[fd00::1]:2379"},
+		{"http", "fd00::1", 2380, "http://[fd00::1]:2380"},
+		{"https", "ip-10-0-0-1.ec2.internal", 2380, "https://ip-10-0-0-1.ec2.internal:2380"},
+		{"http", "i-1.etcd.example.com", 2379, "http://i-1.etcd.example.com:2379"},
+	}
+
+	for _, test := range tests {
+		if url := EtcdURL(test.scheme, test.host, test.port); url != test.url {
+			t.Errorf("EtcdURL(%q, %q, %d) = %q, expected %q", test.scheme, test.host, test.port, url, test.url)
+		}
+	}
+}
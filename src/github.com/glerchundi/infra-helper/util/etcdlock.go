@@ -0,0 +1,165 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package util
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+const (
+	etcdLockMinBackoff = 1 * time.Second
+	etcdLockMaxBackoff = 30 * time.Second
+)
+
+// EtcdLock is a mutual exclusion lock held as an etcd key whose value is the
+// holder name. The key has a TTL so the lock is released even if its holder
+// dies while holding it.
+type EtcdLock struct {
+	keysAPI client.KeysAPI
+	key     string
+	holder  string
+	ttl     time.Duration
+}
+
+func NewEtcdLock(url, key, holder string, ttl time.Duration) (*EtcdLock, error) {
+	hc, err := newEtcdClient(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdLock{
+		keysAPI: client.NewKeysAPI(hc),
+		key:     key,
+		holder:  holder,
+		ttl:     ttl,
+	}, nil
+}
+
+// Lock acquires the lock, retrying with an exponential backoff while it is
+// held by someone else (or etcd fails) until the timeout expires. A lock
+// already held by the same holder (i.e. before a restart) is taken over.
+func (lock *EtcdLock) Lock(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := etcdLockMinBackoff
+
+	for {
+		holder, err := lock.tryLock()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			if holder != "" {
+				return fmt.Errorf("unable to acquire lock %s, held by %s", lock.key, holder)
+			}
+			return fmt.Errorf("unable to acquire lock %s: %v", lock.key, err)
+		}
+
+		if holder != "" {
+			log.Printf("lock %s held by %s, retrying in %s\n", lock.key, holder, backoff)
+		} else {
+			log.Printf("unable to acquire lock %s: %v, retrying in %s\n", lock.key, err, backoff)
+		}
+
+		// jitter avoids instances launched together retrying in lockstep
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff/2))))
+		if backoff *= 2; backoff > etcdLockMaxBackoff {
+			backoff = etcdLockMaxBackoff
+		}
+	}
+}
+
+// tryLock makes a single attempt to acquire the lock, if it is held by
+// someone else its holder is returned along with the error.
+func (lock *EtcdLock) tryLock() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
+	defer cancel()
+
+	_, err := lock.keysAPI.Set(ctx, lock.key, lock.holder, &client.SetOptions{
+		PrevExist: client.PrevNoExist,
+		TTL:       lock.ttl,
+	})
+	if etcdErr, ok := err.(client.Error); !ok || etcdErr.Code != client.ErrorCodeNodeExist {
+		return "", err
+	}
+
+	// refresh the lock if it is already ours
+	_, err = lock.keysAPI.Set(ctx, lock.key, lock.holder, &client.SetOptions{
+		PrevValue: lock.holder,
+		TTL:       lock.ttl,
+	})
+	if err == nil {
+		return "", nil
+	}
+
+	// it may have been released in the meantime
+	resp, getErr := lock.keysAPI.Get(ctx, lock.key, nil)
+	if getErr != nil {
+		return "", err
+	}
+
+	return resp.Node.Value, err
+}
+
+// Unlock releases the lock as long as it is still held by this holder.
+func (lock *EtcdLock) Unlock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
+	defer cancel()
+
+	_, err := lock.keysAPI.Delete(ctx, lock.key, &client.DeleteOptions{
+		PrevValue: lock.holder,
+	})
+	return err
+}
+
+// Holder returns the name of the holder of the lock, empty if it is free.
+func (lock *EtcdLock) Holder() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
+	defer cancel()
+
+	resp, err := lock.keysAPI.Get(ctx, lock.key, nil)
+	if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeKeyNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Node.Value, nil
+}
+
+// UnlockIfHeldBy releases the lock if its holder name starts with the given
+// prefix, no matter this holder, and tells whether it was released.
+func (lock *EtcdLock) UnlockIfHeldBy(prefix string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
+	defer cancel()
+
+	resp, err := lock.keysAPI.Get(ctx, lock.key, nil)
+	if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !strings.HasPrefix(resp.Node.Value, prefix) {
+		return false, nil
+	}
+
+	_, err = lock.keysAPI.Delete(ctx, lock.key, &client.DeleteOptions{
+		PrevValue: resp.Node.Value,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
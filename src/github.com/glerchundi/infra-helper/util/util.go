@@ -4,13 +4,48 @@
 package util
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// HttpGet returns the body of the response whatever its status is.
 func HttpGet(url string) (string, error) {
+	out, _, _, err := httpDo("GET", url, nil, nil)
+	return out, err
+}
+
+// HttpRequest returns the body of the response, failing unless its status is
+// 2xx.
+func HttpRequest(method, url string, headers map[string]string) (string, error) {
+	out, _, err := httpDoChecked(method, url, headers, nil)
+	return out, err
+}
+
+// HttpPost returns the body and headers of the response, failing unless its
+// status is 2xx.
+func HttpPost(url string, headers map[string]string, body string) (string, http.Header, error) {
+	return httpDoChecked("POST", url, headers, strings.NewReader(body))
+}
+
+func httpDoChecked(method, url string, headers map[string]string, body io.Reader) (string, http.Header, error) {
+	out, header, status, err := httpDo(method, url, headers, body)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if status < 200 || status > 299 {
+		return "", nil, fmt.Errorf("%s %s: unexpected status %d", method, url, status)
+	}
+
+	return out, header, nil
+}
+
+func httpDo(method, url string, headers map[string]string, body io.Reader) (string, http.Header, int, error) {
 	client := http.Client{
 		Transport: &http.Transport{
 			Dial: func(network, addr string) (net.Conn, error) {
@@ -19,17 +54,37 @@ func HttpGet(url string) (string, error) {
 		},
 	}
 
-	resp, err := client.Get(url)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", nil, 0, err
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	out, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", nil, 0, err
 	}
 
-	return string(body), nil
+	return string(out), resp.Header, resp.StatusCode, nil
 }
 
+// SplitList splits a comma separated list, trimming blanks and dropping
+// empty items.
+func SplitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2015 Gorka Lerchundi Osa. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHttpStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+	}))
+	defer server.Close()
+
+	// HttpGet keeps returning the body whatever the status is
+	if out, err := HttpGet(server.URL); err != nil || out != "not found" {
+		t.Errorf("HttpGet() = %q, %v", out, err)
+	}
+
+	if _, err := HttpRequest("GET", server.URL, nil); err == nil {
+		t.Errorf("HttpRequest() expected to fail on a 404")
+	}
+
+	if _, _, err := HttpPost(server.URL, nil, ""); err == nil {
+		t.Errorf("HttpPost() expected to fail on a 404")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	for value, expected := range map[string][]string{
+		"":             {},
+		" , ":          {},
+		"a":            {"a"},
+		" a, b ,,c , ": {"a", "b", "c"},
+	} {
+		if actual := SplitList(value); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("SplitList(%q) = %v, expected %v", value, actual, expected)
+		}
+	}
+}